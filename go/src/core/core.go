@@ -1,9 +1,13 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"strings"
 	"time"
 )
@@ -15,11 +19,135 @@ import (
 	. "types"
 )
 
+// Argument checking
+
+// arg_list accepts a list or a vector
+func arg_list(name string, a MalType) ([]MalType, error) {
+	switch seq := a.(type) {
+	case List:
+		return seq.Val, nil
+	case Vector:
+		return seq.Val, nil
+	}
+	return nil, NewTypeError("%s: expected list or vector, got %s", name, TypeName(a))
+}
+
+func arg_hash_map(name string, a MalType) (HashMap, error) {
+	hm, ok := a.(HashMap)
+	if !ok {
+		return HashMap{}, NewTypeError("%s: expected hash-map, got %s", name, TypeName(a))
+	}
+	return hm, nil
+}
+
+// arg_number accepts an integer, as used for indices and counts
+func arg_number(name string, a MalType) (int, error) {
+	n, ok := a.(int)
+	if _, is_float := a.(float64); is_float {
+		return 0, NewTypeError("%s: expected integer, got float", name)
+	}
+	if !ok {
+		return 0, NewTypeError("%s: expected number, got %s", name, TypeName(a))
+	}
+	return n, nil
+}
+
+// arg_string accepts a string that is not a keyword
+func arg_string(name string, a MalType) (string, error) {
+	s, ok := a.(string)
+	if !ok || Keyword_Q(s) {
+		return "", NewTypeError("%s: expected string, got %s", name, TypeName(a))
+	}
+	return s, nil
+}
+
 // Errors/Exceptions
 func throw(a []MalType) (MalType, error) {
 	return nil, MalError{a[0]}
 }
 
+// ex-info exceptions are hash-maps with :type :ex-info, a :message,
+// :data and an optional :cause
+func ex_info(a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	msg, ok := a[0].(string)
+	if !ok || Keyword_Q(msg) {
+		return nil, NewTypeError("ex-info: expected string message")
+	}
+	if a[1] != nil && !HashMap_Q(a[1]) {
+		return nil, NewTypeError("ex-info: expected hash-map data")
+	}
+	var cause MalType
+	if len(a) == 3 {
+		cause = a[2]
+	}
+	return HashMap{map[string]MalType{
+		"\u029etype":    "\u029eex-info",
+		"\u029emessage": msg,
+		"\u029edata":    a[1],
+		"\u029ecause":   cause,
+	}, nil}, nil
+}
+
+func ex_field(key string) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		if hm, ok := a[0].(HashMap); ok {
+			return hm.Val[key], nil
+		}
+		return nil, nil
+	}
+}
+
+func ex_message(a []MalType) (MalType, error) {
+	if s, ok := a[0].(string); ok && !Keyword_Q(s) {
+		return s, nil
+	}
+	return ex_field("\u029emessage")(a)
+}
+
+// stacktrace returns the call stack of an exception handled by a catch*
+// of the evaluation, or that the REPL last reported, innermost first
+func stacktrace(ec *EvalContext, a []MalType) (MalType, error) {
+	lst := []MalType{}
+	for _, f := range ec.ExceptionStack(a[0]) {
+		lst = append(lst, f.String())
+	}
+	return List{lst, nil}, nil
+}
+
+func symbol(a []MalType) (MalType, error) {
+	name, e := arg_string("symbol", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return Symbol{name}, nil
+}
+
+func keyword(a []MalType) (MalType, error) {
+	if Keyword_Q(a[0]) {
+		return a[0], nil
+	}
+	name, e := arg_string("keyword", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return NewKeyword(name)
+}
+
+func equal_Q(a []MalType) (MalType, error) {
+	if len(a) == 0 {
+		return nil, NewArityError("wrong number of arguments (0 instead of at least 1)")
+	}
+	for i := 1; i < len(a); i += 1 {
+		if !Equal_Q(a[i-1], a[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func fn_q(a []MalType) (MalType, error) {
 	switch f := a[0].(type) {
 	case MalFunc:
@@ -43,21 +171,97 @@ func str(a []MalType) (MalType, error) {
 	return printer.Pr_list(a, false, "", "", ""), nil
 }
 
-func prn(a []MalType) (MalType, error) {
-	fmt.Println(printer.Pr_list(a, true, "", "", " "))
+// Out is the dynamic var *out* that prn and println write to
+var Out = &Var{Symbol{"*out*"}, &Handle{"stdout", nil, os.Stdout, nil}, nil}
+
+// out_writer returns the writer *out* is bound to in the evaluation ec
+func out_writer(ec *EvalContext) (io.Writer, error) {
+	h, ok := ec.Deref(Out).(*Handle)
+	if !ok || h.Writer == nil {
+		return nil, errors.New("*out* is not bound to a writer")
+	}
+	return h.Writer, nil
+}
+
+func prn(ec *EvalContext, a []MalType) (MalType, error) {
+	w, e := out_writer(ec)
+	if e != nil {
+		return nil, e
+	}
+	fmt.Fprintln(w, printer.Pr_list(a, true, "", "", " "))
 	return nil, nil
 }
 
-func println(a []MalType) (MalType, error) {
-	fmt.Println(printer.Pr_list(a, false, "", "", " "))
+func println(ec *EvalContext, a []MalType) (MalType, error) {
+	w, e := out_writer(ec)
+	if e != nil {
+		return nil, e
+	}
+	fmt.Fprintln(w, printer.Pr_list(a, false, "", "", " "))
 	return nil, nil
 }
 
+func string_writer(a []MalType) (MalType, error) {
+	return &Handle{"string", nil, &bytes.Buffer{}, nil}, nil
+}
+
+func read_string(a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	str, e := arg_string("read-string", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if len(a) == 2 {
+		// optional file name recorded in source positions
+		file, e := arg_string("read-string", a[1])
+		if e != nil {
+			return nil, e
+		}
+		return reader.Read_str_file(str, file)
+	}
+	return reader.Read_str(str)
+}
+
+// (read-forms str) and (read-forms str file) return a list of all the
+// forms in str, as read-string reads the first
+func read_forms(a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	strs, e := string_args("read-forms", a)
+	if e != nil {
+		return nil, e
+	}
+	file := ""
+	if len(strs) == 2 {
+		file = strs[1]
+	}
+	forms, e := reader.Read_forms_file(strs[0], file)
+	if e != nil {
+		return nil, e
+	}
+	return List{forms, nil}, nil
+}
+
+func read_line(a []MalType) (MalType, error) {
+	prompt, e := arg_string("readline", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return readline.Readline(prompt)
+}
+
 func slurp(a []MalType) (MalType, error) {
-	b, e := ioutil.ReadFile(a[0].(string))
+	path, e := arg_string("slurp", a[0])
 	if e != nil {
 		return nil, e
 	}
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, io_error("slurp", e)
+	}
 	return string(b), nil
 }
 
@@ -82,16 +286,17 @@ func assoc(a []MalType) (MalType, error) {
 	if len(a)%2 != 1 {
 		return nil, errors.New("assoc requires odd number of arguments")
 	}
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("assoc called on non-hash map")
+	hm, e := arg_hash_map("assoc", a[0])
+	if e != nil {
+		return nil, e
 	}
-	new_hm := copy_hash_map(a[0].(HashMap))
+	new_hm := copy_hash_map(hm)
 	for i := 1; i < len(a); i += 2 {
-		key := a[i]
-		if !String_Q(key) {
-			return nil, errors.New("assoc called with non-string key")
+		key, e := arg_key("assoc", a[i])
+		if e != nil {
+			return nil, e
 		}
-		new_hm.Val[key.(string)] = a[i+1]
+		new_hm.Val[key] = a[i+1]
 	}
 	return new_hm, nil
 }
@@ -100,16 +305,17 @@ func dissoc(a []MalType) (MalType, error) {
 	if len(a) < 2 {
 		return nil, errors.New("dissoc requires at least 3 arguments")
 	}
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("dissoc called on non-hash map")
+	hm, e := arg_hash_map("dissoc", a[0])
+	if e != nil {
+		return nil, e
 	}
-	new_hm := copy_hash_map(a[0].(HashMap))
+	new_hm := copy_hash_map(hm)
 	for i := 1; i < len(a); i += 1 {
-		key := a[i]
-		if !String_Q(key) {
-			return nil, errors.New("dissoc called with non-string key")
+		key, e := arg_key("dissoc", a[i])
+		if e != nil {
+			return nil, e
 		}
-		delete(new_hm.Val, key.(string))
+		delete(new_hm.Val, key)
 	}
 	return new_hm, nil
 }
@@ -118,46 +324,52 @@ func get(a []MalType) (MalType, error) {
 	if Nil_Q(a[0]) {
 		return nil, nil
 	}
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("get called on non-hash map")
+	hm, e := arg_hash_map("get", a[0])
+	if e != nil {
+		return nil, e
 	}
-	if !String_Q(a[1]) {
-		return nil, errors.New("get called with non-string key")
+	key, e := arg_key("get", a[1])
+	if e != nil {
+		return nil, e
 	}
-	return a[0].(HashMap).Val[a[1].(string)], nil
+	return hm.Val[key], nil
 }
 
 func contains_Q(hm MalType, key MalType) (MalType, error) {
 	if Nil_Q(hm) {
 		return false, nil
 	}
-	if !HashMap_Q(hm) {
-		return nil, errors.New("get called on non-hash map")
+	m, e := arg_hash_map("contains?", hm)
+	if e != nil {
+		return nil, e
 	}
-	if !String_Q(key) {
-		return nil, errors.New("get called with non-string key")
+	k, e := arg_key("contains?", key)
+	if e != nil {
+		return nil, e
 	}
-	_, ok := hm.(HashMap).Val[key.(string)]
+	_, ok := m.Val[k]
 	return ok, nil
 }
 
 func keys(a []MalType) (MalType, error) {
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("keys called on non-hash map")
+	hm, e := arg_hash_map("keys", a[0])
+	if e != nil {
+		return nil, e
 	}
 	slc := []MalType{}
-	for k, _ := range a[0].(HashMap).Val {
+	for k, _ := range hm.Val {
 		slc = append(slc, k)
 	}
 	return List{slc, nil}, nil
 }
 
 func vals(a []MalType) (MalType, error) {
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("keys called on non-hash map")
+	hm, e := arg_hash_map("vals", a[0])
+	if e != nil {
+		return nil, e
 	}
 	slc := []MalType{}
-	for _, v := range a[0].(HashMap).Val {
+	for _, v := range hm.Val {
 		slc = append(slc, v)
 	}
 	return List{slc, nil}, nil
@@ -165,40 +377,55 @@ func vals(a []MalType) (MalType, error) {
 
 // Sequence functions
 
+// check_alloc charges the building of a sequence of n elements to the
+// allocation budget of the evaluation
+func check_alloc(ec *EvalContext, n int) error {
+	return ec.Alloc(uint64(n) * SlotSize)
+}
+
 func cons(a []MalType) (MalType, error) {
 	val := a[0]
-	lst, e := GetSlice(a[1])
+	lst, e := arg_list("cons", a[1])
 	if e != nil {
 		return nil, e
 	}
 	return List{append([]MalType{val}, lst...), nil}, nil
 }
 
-func concat(a []MalType) (MalType, error) {
+func concat(ec *EvalContext, a []MalType) (MalType, error) {
 	if len(a) == 0 {
 		return List{}, nil
 	}
-	slc1, e := GetSlice(a[0])
-	if e != nil {
-		return nil, e
-	}
-	for i := 1; i < len(a); i += 1 {
-		slc2, e := GetSlice(a[i])
+	slcs := make([][]MalType, len(a))
+	total := 0
+	for i := 0; i < len(a); i += 1 {
+		slc, e := arg_list("concat", a[i])
 		if e != nil {
 			return nil, e
 		}
-		slc1 = append(slc1, slc2...)
+		slcs[i] = slc
+		total += len(slc)
+	}
+	if e := check_alloc(ec, total); e != nil {
+		return nil, e
+	}
+	slc1 := make([]MalType, 0, total)
+	for _, slc := range slcs {
+		slc1 = append(slc1, slc...)
 	}
 	return List{slc1, nil}, nil
 }
 
 func nth(a []MalType) (MalType, error) {
-	slc, e := GetSlice(a[0])
+	slc, e := arg_list("nth", a[0])
 	if e != nil {
 		return nil, e
 	}
-	idx := a[1].(int)
-	if idx < len(slc) {
+	idx, e := arg_number("nth", a[1])
+	if e != nil {
+		return nil, e
+	}
+	if idx >= 0 && idx < len(slc) {
 		return slc[idx], nil
 	} else {
 		return nil, errors.New("nth: index out of range")
@@ -212,7 +439,7 @@ func first(a []MalType) (MalType, error) {
 	if a[0] == nil {
 		return nil, nil
 	}
-	slc, e := GetSlice(a[0])
+	slc, e := arg_list("first", a[0])
 	if e != nil {
 		return nil, e
 	}
@@ -226,7 +453,7 @@ func rest(a []MalType) (MalType, error) {
 	if a[0] == nil {
 		return List{}, nil
 	}
-	slc, e := GetSlice(a[0])
+	slc, e := arg_list("rest", a[0])
 	if e != nil {
 		return nil, e
 	}
@@ -245,7 +472,7 @@ func empty_Q(a []MalType) (MalType, error) {
 	case nil:
 		return true, nil
 	default:
-		return nil, errors.New("empty? called on non-sequence")
+		return nil, NewTypeError("empty?: expected list or vector, got %s", TypeName(a[0]))
 	}
 }
 
@@ -260,11 +487,11 @@ func count(a []MalType) (MalType, error) {
 	case nil:
 		return 0, nil
 	default:
-		return nil, errors.New("count called on non-sequence")
+		return nil, NewTypeError("count: expected list or vector, got %s", TypeName(a[0]))
 	}
 }
 
-func apply(a []MalType) (MalType, error) {
+func apply(ec *EvalContext, a []MalType) (MalType, error) {
 	if len(a) < 2 {
 		return nil, errors.New("apply requires at least 2 args")
 	}
@@ -273,29 +500,12 @@ func apply(a []MalType) (MalType, error) {
 	for _, b := range a[1 : len(a)-1] {
 		args = append(args, b)
 	}
-	last, e := GetSlice(a[len(a)-1])
+	last, e := arg_list("apply", a[len(a)-1])
 	if e != nil {
 		return nil, e
 	}
 	args = append(args, last...)
-	return Apply(f, args)
-}
-
-func do_map(a []MalType) (MalType, error) {
-	f := a[0]
-	results := []MalType{}
-	args, e := GetSlice(a[1])
-	if e != nil {
-		return nil, e
-	}
-	for _, arg := range args {
-		res, e := Apply(f, []MalType{arg})
-		results = append(results, res)
-		if e != nil {
-			return nil, e
-		}
-	}
-	return List{results, nil}, nil
+	return TailApply(ec, f, args)
 }
 
 func conj(a []MalType) (MalType, error) {
@@ -317,16 +527,17 @@ func conj(a []MalType) (MalType, error) {
 		return Vector{new_slc, nil}, nil
 	}
 
-	if !HashMap_Q(a[0]) {
-		return nil, errors.New("dissoc called on non-hash map")
+	hm, ok := a[0].(HashMap)
+	if !ok {
+		return nil, NewTypeError("conj: expected list, vector or hash-map, got %s", TypeName(a[0]))
 	}
-	new_hm := copy_hash_map(a[0].(HashMap))
+	new_hm := copy_hash_map(hm)
 	for i := 1; i < len(a); i += 1 {
-		key := a[i]
-		if !String_Q(key) {
-			return nil, errors.New("dissoc called with non-string key")
+		key, e := arg_key("conj", a[i])
+		if e != nil {
+			return nil, e
 		}
-		delete(new_hm.Val, key.(string))
+		delete(new_hm.Val, key)
 	}
 	return new_hm, nil
 }
@@ -356,7 +567,7 @@ func seq(a []MalType) (MalType, error) {
 		}
 		return List{new_slc, nil}, nil
 	}
-	return nil, errors.New("seq requires string or list or vector or nil")
+	return nil, NewTypeError("seq: expected list, vector, string or nil, got %s", TypeName(a[0]))
 }
 
 // Metadata functions
@@ -377,7 +588,7 @@ func with_meta(a []MalType) (MalType, error) {
 		fn.Meta = m
 		return fn, nil
 	default:
-		return nil, errors.New("with-meta not supported on type")
+		return nil, NewTypeError("with-meta: expected function or collection, got %s", TypeName(obj))
 	}
 }
 
@@ -395,35 +606,38 @@ func meta(a []MalType) (MalType, error) {
 	case MalFunc:
 		return tobj.Meta, nil
 	default:
-		return nil, errors.New("meta not supported on type")
+		return nil, NewTypeError("meta: expected function or collection, got %s", TypeName(a[0]))
 	}
 }
 
 // Atom functions
 func deref(a []MalType) (MalType, error) {
 	if !Atom_Q(a[0]) {
-		return nil, errors.New("deref called with non-atom")
+		return nil, NewTypeError("deref: expected atom, got %s", TypeName(a[0]))
 	}
 	return a[0].(*Atom).Val, nil
 }
 
 func reset_BANG(a []MalType) (MalType, error) {
 	if !Atom_Q(a[0]) {
-		return nil, errors.New("reset! called with non-atom")
+		return nil, NewTypeError("reset!: expected atom, got %s", TypeName(a[0]))
 	}
 	a[0].(*Atom).Set(a[1])
 	return a[1], nil
 }
 
-func swap_BANG(a []MalType) (MalType, error) {
+func swap_BANG(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) < 2 {
+		return nil, NewArityError("swap! requires at least 2 arguments")
+	}
 	if !Atom_Q(a[0]) {
-		return nil, errors.New("swap! called with non-atom")
+		return nil, NewTypeError("swap!: expected atom, got %s", TypeName(a[0]))
 	}
 	atm := a[0].(*Atom)
 	args := []MalType{atm.Val}
 	f := a[1]
 	args = append(args, a[2:]...)
-	res, e := Apply(f, args)
+	res, e := Apply(ec, f, args)
 	if e != nil {
 		return nil, e
 	}
@@ -433,40 +647,35 @@ func swap_BANG(a []MalType) (MalType, error) {
 
 // core namespace
 var NS = map[string]MalType{
-	"=":       call2b(Equal_Q),
-	"throw":   call1e(throw),
-	"nil?":    call1b(Nil_Q),
-	"true?":   call1b(True_Q),
-	"false?":  call1b(False_Q),
-	"symbol":  call1e(func(a []MalType) (MalType, error) { return Symbol{a[0].(string)}, nil }),
-	"symbol?": call1b(Symbol_Q),
-	"string?": call1e(func(a []MalType) (MalType, error) { return (String_Q(a[0]) && !Keyword_Q(a[0])), nil }),
-	"keyword": call1e(func(a []MalType) (MalType, error) {
-		if Keyword_Q(a[0]) {
-			return a[0], nil
-		} else {
-			return NewKeyword(a[0].(string))
-		}
-	}),
+	"=":           callNe(equal_Q),
+	"throw":       call1e(throw),
+	"nil?":        call1b(Nil_Q),
+	"true?":       call1b(True_Q),
+	"false?":      call1b(False_Q),
+	"symbol":      call1e(symbol),
+	"symbol?":     call1b(Symbol_Q),
+	"string?":     call1e(func(a []MalType) (MalType, error) { return (String_Q(a[0]) && !Keyword_Q(a[0])), nil }),
+	"keyword":     call1e(keyword),
 	"keyword?":    call1b(Keyword_Q),
 	"number?":     call1b(Number_Q),
 	"fn?":         call1e(fn_q),
 	"macro?":      call1e(func(a []MalType) (MalType, error) { return MalFunc_Q(a[0]) && a[0].(MalFunc).GetMacro(), nil }),
 	"pr-str":      callNe(pr_str),
 	"str":         callNe(str),
-	"prn":         callNe(prn),
-	"println":     callNe(println),
-	"read-string": call1e(func(a []MalType) (MalType, error) { return reader.Read_str(a[0].(string)) }),
+	"prn":         callNc(prn),
+	"println":     callNc(println),
+	"read-string": callNe(read_string), // 1 or 2
+	"read-forms":  callNe(read_forms),  // 1 or 2
 	"slurp":       call1e(slurp),
-	"readline":    call1e(func(a []MalType) (MalType, error) { return readline.Readline(a[0].(string)) }),
-	"<":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) < a[1].(int), nil }),
-	"<=":          call2e(func(a []MalType) (MalType, error) { return a[0].(int) <= a[1].(int), nil }),
-	">":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) > a[1].(int), nil }),
-	">=":          call2e(func(a []MalType) (MalType, error) { return a[0].(int) >= a[1].(int), nil }),
-	"+":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) + a[1].(int), nil }),
-	"-":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) - a[1].(int), nil }),
-	"*":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) * a[1].(int), nil }),
-	"/":           call2e(func(a []MalType) (MalType, error) { return a[0].(int) / a[1].(int), nil }),
+	"readline":    call1e(read_line),
+	"<":           callNe(compare_chain("<", func(c int) bool { return c < 0 })),
+	"<=":          callNe(compare_chain("<=", func(c int) bool { return c <= 0 })),
+	">":           callNe(compare_chain(">", func(c int) bool { return c > 0 })),
+	">=":          callNe(compare_chain(">=", func(c int) bool { return c >= 0 })),
+	"+":           callNe(arith("+", 0, true, add)),
+	"-":           callNe(arith("-", 0, false, subtract)),
+	"*":           callNe(arith("*", 1, true, multiply)),
+	"/":           callNe(arith("/", 1, false, divide)),
 	"time-ms":     call0e(time_ms),
 	"list":        callNe(func(a []MalType) (MalType, error) { return List{a, nil}, nil }),
 	"list?":       call1b(List_Q),
@@ -482,15 +691,15 @@ var NS = map[string]MalType{
 	"vals":        call1e(vals),
 	"sequential?": call1b(Sequential_Q),
 	"cons":        call2e(cons),
-	"concat":      callNe(concat),
+	"concat":      callNc(concat),
 	"nth":         call2e(nth),
 	"first":       call1e(first),
 	"rest":        call1e(rest),
 	"empty?":      call1e(empty_Q),
 	"count":       call1e(count),
-	"apply":       callNe(apply), // at least 2
-	"map":         call2e(do_map),
-	"conj":        callNe(conj), // at least 2
+	"apply":       callNc(apply),  // at least 2
+	"map":         callNc(do_map), // at least 2
+	"conj":        callNe(conj),   // at least 2
 	"seq":         call1e(seq),
 	"with-meta":   call2e(with_meta),
 	"meta":        call1e(meta),
@@ -498,57 +707,254 @@ var NS = map[string]MalType{
 	"atom?":       call1b(Atom_Q),
 	"deref":       call1e(deref),
 	"reset!":      call2e(reset_BANG),
-	"swap!":       callNe(swap_BANG),
+	"swap!":       callNc(swap_BANG),
+
+	// Exceptions
+	"ex-info":    callNe(ex_info), // 2 or 3
+	"ex-data":    call1e(ex_field("\u029edata")),
+	"ex-message": call1e(ex_message),
+	"ex-cause":   call1e(ex_field("\u029ecause")),
+	"stacktrace": call1c(stacktrace),
+
+	// I/O
+	"string-writer": call0e(string_writer),
+	"spit":          callNe(spit), // at least 2
+	"file-exists?":  call1e(file_exists_Q),
+	"directory?":    call1e(directory_Q),
+	"list-dir":      call1e(list_dir),
+	"mkdir":         call1e(path_op("mkdir", mkdir)),
+	"delete-file":   call1e(path_op("delete-file", delete_file)),
+	"rename-file":   call2e(path_op("rename-file", rename_file)),
+	"file-info":     call1e(file_info),
+	"reader":        call1e(file_reader),
+	"writer":        callNe(file_writer), // at least 1
+	"close":         call1e(close_handle),
+	"read-line":     call1e(read_handle_line),
+	"line-seq":      call1e(line_seq),
+
+	// Processes and the environment
+	"sh":           callNc(sh),
+	"process":      callNc(process),
+	"process-in":   call1e(process_stream("process-in", func(p *Process) *Handle { return p.In })),
+	"process-out":  call1e(process_stream("process-out", func(p *Process) *Handle { return p.Out })),
+	"process-err":  call1e(process_stream("process-err", func(p *Process) *Handle { return p.Err })),
+	"process-wait": call1e(process_wait),
+	"process-kill": call1e(process_kill),
+	"getenv":       callNe(getenv), // 0 or 1
+	"setenv":       call2e(setenv),
+	"exit":         callNe(exit), // 0 or 1
+
+	// JSON
+	"json-parse": callNe(json_parse), // at least 1
+	"json-str":   callNe(json_str),   // at least 1
+
+	// Dates and times
+	"now":          call0e(now),
+	"nano-time":    call0e(nano_time),
+	"inst?":        call1b(Instant_Q),
+	"inst-ms":      call1e(inst_ms),
+	"inst-from-ms": call1e(inst_from_ms),
+	"parse-time":   callNe(parse_time),  // 1 to 3
+	"format-time":  callNe(format_time), // 1 or 2
+	"in-zone":      call2e(in_zone),
+	"time-fields":  call1e(time_fields),
+	"duration":     callNe(duration), // 1 or 2
+	"time-add":     call2e(time_add),
+	"time-diff":    call2e(time_diff),
+	"before?":      call2e(time_order("before?", true)),
+	"after?":       call2e(time_order("after?", false)),
+	"sleep":        call1c(sleep),
+
+	// Hashing and encoding
+	"md5":           call1e(digest("md5")),
+	"sha1":          call1e(digest("sha1")),
+	"sha256":        call1e(digest("sha256")),
+	"hmac":          call3e(do_hmac),
+	"base64-encode": callNe(base64_encode), // 1 or 2
+	"base64-decode": callNe(base64_decode), // 1 or 2
+	"hex-encode":    call1e(hex_encode),
+	"hex-decode":    call1e(hex_decode),
+	"url-encode":    call1e(url_encode),
+	"url-decode":    call1e(url_decode),
+	"random-uuid":   call0e(random_uuid),
+
+	// Formatting
+	"format": callNe(format), // at least 1
+	"printf": callNc(printf), // at least 1
+
+	// Delays and memoization
+	"delay*":    call1e(delay),
+	"force":     call1c(force),
+	"realized?": call1e(realized_Q),
+	"memoize":   callNe(memoize), // 1 or 2
+
+	// Strings
+	"subs":         callNe(subs),  // 2 or 3
+	"split":        callNe(split), // 2 or 3
+	"split-lines":  call1e(split_lines),
+	"join":         callNe(join), // 1 or 2
+	"upper-case":   call1e(string_fn("upper-case", upper_case)),
+	"lower-case":   call1e(string_fn("lower-case", lower_case)),
+	"capitalize":   call1e(string_fn("capitalize", capitalize)),
+	"trim":         call1e(string_fn("trim", trim)),
+	"triml":        call1e(string_fn("triml", triml)),
+	"trimr":        call1e(string_fn("trimr", trimr)),
+	"blank?":       call1e(blank_Q),
+	"starts-with?": call2e(string_pred("starts-with?", strings.HasPrefix)),
+	"ends-with?":   call2e(string_pred("ends-with?", strings.HasSuffix)),
+	"includes?":    call2e(string_pred("includes?", strings.Contains)),
+	"index-of":     callNe(index_of), // 2 or 3
+	"replace":      call3c(replace),
+
+	// Regular expressions
+	"re-pattern": call1e(re_pattern),
+	"re-find":    call2e(re_find),
+	"re-matches": call2e(re_matches),
+	"re-seq":     call2c(re_seq),
+	"re-groups":  call2e(re_groups),
+	"regex?":     call1b(Regex_Q),
+
+	// Math
+	"quot":       call2e(binary_arith("quot", quot)),
+	"rem":        call2e(binary_arith("rem", rem)),
+	"mod":        call2e(binary_arith("mod", mod)),
+	"inc":        call1e(inc),
+	"dec":        call1e(dec),
+	"abs":        call1e(abs),
+	"min":        callNe(extremum("min", func(c int) bool { return c < 0 })),
+	"max":        callNe(extremum("max", func(c int) bool { return c > 0 })),
+	"pow":        call2e(pow),
+	"sqrt":       call1e(float_fn("sqrt", math.Sqrt)),
+	"floor":      call1e(to_int("floor", math.Floor)),
+	"ceil":       call1e(to_int("ceil", math.Ceil)),
+	"round":      call1e(to_int("round", math.Round)),
+	"sin":        call1e(float_fn("sin", math.Sin)),
+	"cos":        call1e(float_fn("cos", math.Cos)),
+	"tan":        call1e(float_fn("tan", math.Tan)),
+	"asin":       call1e(float_fn("asin", math.Asin)),
+	"acos":       call1e(float_fn("acos", math.Acos)),
+	"atan":       call1e(float_fn("atan", math.Atan)),
+	"atan2":      call2e(atan2),
+	"float?":     call1b(float_Q),
+	"rand":       callNe(random), // 0 or 1
+	"rand-int":   call1e(rand_int),
+	"rand-seed!": call1e(rand_seed),
+
+	// Sequences
+	"filter":       call2c(keep("filter", true)),
+	"remove":       call2c(keep("remove", false)),
+	"reduce":       callNc(reduce), // 2 or 3
+	"reduce-kv":    call3c(reduce_kv),
+	"take":         call2e(take),
+	"drop":         call2e(drop),
+	"partition":    callNe(partition), // 2 or 3
+	"partition-by": call2c(partition_by),
+	"sort":         callNc(do_sort), // 1 or 2
+	"sort-by":      callNc(sort_by), // 2 or 3
+	"group-by":     call2c(group_by),
+	"frequencies":  call1e(frequencies),
+	"distinct":     call1e(distinct),
+	"range":        callNc(do_range), // 1 to 3
+	"into":         call2e(into),
+	"zipmap":       call2e(zipmap),
+	"interleave":   callNe(interleave),
+	"mapcat":       callNc(mapcat), // at least 2
+	"last":         call1e(last),
+	"butlast":      call1e(butlast),
+	"reverse":      call1e(reverse),
 }
 
 // callXX functions check the number of arguments
-func call0e(f func([]MalType) (MalType, error)) func([]MalType) (MalType, error) {
-	return func(args []MalType) (MalType, error) {
+func call0e(f func([]MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		if len(args) != 0 {
-			return nil, fmt.Errorf("wrong number of arguments (%d instead of 0)", len(args))
+			return nil, NewArityError("wrong number of arguments (%d instead of 0)", len(args))
 		}
 		return f(args)
 	}
 }
 
-func call1e(f func([]MalType) (MalType, error)) func([]MalType) (MalType, error) {
-	return func(args []MalType) (MalType, error) {
+func call1e(f func([]MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("wrong number of arguments (%d instead of 1)", len(args))
+			return nil, NewArityError("wrong number of arguments (%d instead of 1)", len(args))
 		}
 		return f(args)
 	}
 }
 
-func call2e(f func([]MalType) (MalType, error)) func([]MalType) (MalType, error) {
-	return func(args []MalType) (MalType, error) {
+func call2e(f func([]MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("wrong number of arguments (%d instead of 2)", len(args))
+			return nil, NewArityError("wrong number of arguments (%d instead of 2)", len(args))
 		}
 		return f(args)
 	}
 }
 
-func callNe(f func([]MalType) (MalType, error)) func([]MalType) (MalType, error) {
+func call3e(f func([]MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
+		if len(args) != 3 {
+			return nil, NewArityError("wrong number of arguments (%d instead of 3)", len(args))
+		}
+		return f(args)
+	}
+}
+
+func callNe(f func([]MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
 	// just for documenting purposes, does not check anything
-	return func(args []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		return f(args)
 	}
 }
 
-func call1b(f func(MalType) bool) func([]MalType) (MalType, error) {
-	return func(args []MalType) (MalType, error) {
+// callXc functions are the callXe ones for functions that take part in
+// the evaluation, calling functions or using its limits
+func call1c(f func(*EvalContext, []MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(ec *EvalContext, args []MalType) (MalType, error) {
+		if len(args) != 1 {
+			return nil, NewArityError("wrong number of arguments (%d instead of 1)", len(args))
+		}
+		return f(ec, args)
+	}
+}
+
+func call2c(f func(*EvalContext, []MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(ec *EvalContext, args []MalType) (MalType, error) {
+		if len(args) != 2 {
+			return nil, NewArityError("wrong number of arguments (%d instead of 2)", len(args))
+		}
+		return f(ec, args)
+	}
+}
+
+func call3c(f func(*EvalContext, []MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	return func(ec *EvalContext, args []MalType) (MalType, error) {
+		if len(args) != 3 {
+			return nil, NewArityError("wrong number of arguments (%d instead of 3)", len(args))
+		}
+		return f(ec, args)
+	}
+}
+
+func callNc(f func(*EvalContext, []MalType) (MalType, error)) func(*EvalContext, []MalType) (MalType, error) {
+	// just for documenting purposes, does not check anything
+	return f
+}
+
+func call1b(f func(MalType) bool) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("wrong number of arguments (%d instead of 1)", len(args))
+			return nil, NewArityError("wrong number of arguments (%d instead of 1)", len(args))
 		}
 		return f(args[0]), nil
 	}
 }
 
-func call2b(f func(MalType, MalType) bool) func([]MalType) (MalType, error) {
-	return func(args []MalType) (MalType, error) {
+func call2b(f func(MalType, MalType) bool) func(*EvalContext, []MalType) (MalType, error) {
+	return func(_ *EvalContext, args []MalType) (MalType, error) {
 		if len(args) != 2 {
-			return nil, fmt.Errorf("wrong number of arguments (%d instead of 2)", len(args))
+			return nil, NewArityError("wrong number of arguments (%d instead of 2)", len(args))
 		}
 		return f(args[0], args[1]), nil
 	}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// Hashing and encoding. Data is given as a string, taken as its bytes,
+// or as a sequence of byte values; results are strings.
+
+func arg_bytes(name string, a MalType) ([]byte, error) {
+	if str, ok := a.(string); ok && !Keyword_Q(str) {
+		return []byte(str), nil
+	}
+	slc, e := GetSlice(a)
+	if e != nil {
+		return nil, NewTypeError("%s: expected string or bytes, got %s", name, TypeName(a))
+	}
+	bytes := make([]byte, len(slc))
+	for i, x := range slc {
+		b, ok := x.(int)
+		if !ok || b < 0 || b > 255 {
+			return nil, NewTypeError("%s: expected byte values, got %s", name, printer.Pr_str(x, true))
+		}
+		bytes[i] = byte(b)
+	}
+	return bytes, nil
+}
+
+var hashes = map[string]func() hash.Hash{
+	"\u029emd5":    md5.New,
+	"\u029esha1":   sha1.New,
+	"\u029esha256": sha256.New,
+}
+
+// digest makes a function returning the hex digest of its argument
+// with one of hashes
+func digest(name string) func([]MalType) (MalType, error) {
+	h := hashes["\u029e"+name]
+	return func(a []MalType) (MalType, error) {
+		data, e := arg_bytes(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		d := h()
+		d.Write(data)
+		return hex.EncodeToString(d.Sum(nil)), nil
+	}
+}
+
+// (hmac algorithm key message) with algorithm :md5, :sha1 or :sha256
+func do_hmac(a []MalType) (MalType, error) {
+	alg, _ := a[0].(string)
+	h, ok := hashes[alg]
+	if !ok {
+		return nil, NewTypeError("hmac: algorithm must be :md5, :sha1 or :sha256")
+	}
+	key, e := arg_bytes("hmac", a[1])
+	if e != nil {
+		return nil, e
+	}
+	msg, e := arg_bytes("hmac", a[2])
+	if e != nil {
+		return nil, e
+	}
+	mac := hmac.New(h, key)
+	mac.Write(msg)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// base64_encoding returns the standard encoding, or the URL-safe one
+// if the optional argument is :url
+func base64_encoding(name string, a []MalType) (*base64.Encoding, error) {
+	switch len(a) {
+	case 1:
+		return base64.StdEncoding, nil
+	case 2:
+		if a[1] == "\u029eurl" {
+			return base64.URLEncoding, nil
+		}
+		return nil, NewTypeError("%s: unknown encoding %s", name, printer.Pr_str(a[1], true))
+	}
+	return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+}
+
+func base64_encode(a []MalType) (MalType, error) {
+	enc, e := base64_encoding("base64-encode", a)
+	if e != nil {
+		return nil, e
+	}
+	data, e := arg_bytes("base64-encode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return enc.EncodeToString(data), nil
+}
+
+func base64_decode(a []MalType) (MalType, error) {
+	enc, e := base64_encoding("base64-decode", a)
+	if e != nil {
+		return nil, e
+	}
+	str, e := arg_string("base64-decode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	data, e := enc.DecodeString(str)
+	if e != nil {
+		return nil, fmt.Errorf("base64-decode: %v", e)
+	}
+	return string(data), nil
+}
+
+func hex_encode(a []MalType) (MalType, error) {
+	data, e := arg_bytes("hex-encode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func hex_decode(a []MalType) (MalType, error) {
+	str, e := arg_string("hex-decode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	data, e := hex.DecodeString(str)
+	if e != nil {
+		return nil, fmt.Errorf("hex-decode: %v", e)
+	}
+	return string(data), nil
+}
+
+// url_encode escapes a string for use in a URL query
+func url_encode(a []MalType) (MalType, error) {
+	str, e := arg_string("url-encode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return url.QueryEscape(str), nil
+}
+
+func url_decode(a []MalType) (MalType, error) {
+	str, e := arg_string("url-decode", a[0])
+	if e != nil {
+		return nil, e
+	}
+	res, e := url.QueryUnescape(str)
+	if e != nil {
+		return nil, fmt.Errorf("url-decode: %v", e)
+	}
+	return res, nil
+}
+
+// random_uuid returns a version 4 UUID
+func random_uuid(a []MalType) (MalType, error) {
+	var u [16]byte
+	if _, e := rand.Read(u[:]); e != nil {
+		return nil, e
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
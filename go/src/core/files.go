@@ -0,0 +1,290 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// Files and the filesystem. Failed operations throw an io-error.
+
+func io_error(name string, e error) error {
+	return TypedError{"io-error", fmt.Errorf("%s: %v", name, e)}
+}
+
+// append_option reads the options after the fixed arguments of spit
+// and writer, of which only :append is known
+func append_option(name string, opts []MalType) (bool, error) {
+	if len(opts)%2 != 0 {
+		return false, NewArityError("%s: options must be key value pairs", name)
+	}
+	append_ := false
+	for i := 0; i < len(opts); i += 2 {
+		if opts[i] != "\u029eappend" {
+			return false, NewTypeError("%s: unknown option %s", name, printer.Pr_str(opts[i], true))
+		}
+		append_ = truthy(opts[i+1])
+	}
+	return append_, nil
+}
+
+func open_for_writing(name string, path string, append_ bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if append_ {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, e := os.OpenFile(path, flags, 0666)
+	if e != nil {
+		return nil, io_error(name, e)
+	}
+	return f, nil
+}
+
+// (spit path content & opts) writes content as str would print it
+func spit(a []MalType) (MalType, error) {
+	if len(a) < 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 2)", len(a))
+	}
+	path, e := arg_string("spit", a[0])
+	if e != nil {
+		return nil, e
+	}
+	append_, e := append_option("spit", a[2:])
+	if e != nil {
+		return nil, e
+	}
+	f, e := open_for_writing("spit", path, append_)
+	if e != nil {
+		return nil, e
+	}
+	_, e = io.WriteString(f, printer.Pr_str(a[1], false))
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+	if e != nil {
+		return nil, io_error("spit", e)
+	}
+	return nil, nil
+}
+
+func file_exists_Q(a []MalType) (MalType, error) {
+	path, e := arg_string("file-exists?", a[0])
+	if e != nil {
+		return nil, e
+	}
+	_, e = os.Stat(path)
+	return e == nil, nil
+}
+
+func directory_Q(a []MalType) (MalType, error) {
+	path, e := arg_string("directory?", a[0])
+	if e != nil {
+		return nil, e
+	}
+	info, e := os.Stat(path)
+	return e == nil && info.IsDir(), nil
+}
+
+// list_dir returns the sorted names of the entries of a directory
+func list_dir(a []MalType) (MalType, error) {
+	path, e := arg_string("list-dir", a[0])
+	if e != nil {
+		return nil, e
+	}
+	infos, e := ioutil.ReadDir(path)
+	if e != nil {
+		return nil, io_error("list-dir", e)
+	}
+	names := make([]MalType, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return List{names, nil}, nil
+}
+
+// path_op makes a function of a path and the arguments after it that
+// returns nil on success
+func path_op(name string, op func(path string, rest []string) error) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		paths, e := string_args(name, a)
+		if e != nil {
+			return nil, e
+		}
+		if e = op(paths[0], paths[1:]); e != nil {
+			return nil, io_error(name, e)
+		}
+		return nil, nil
+	}
+}
+
+// mkdir creates parent directories as needed
+func mkdir(path string, _ []string) error {
+	return os.MkdirAll(path, 0777)
+}
+
+func delete_file(path string, _ []string) error {
+	return os.Remove(path)
+}
+
+func rename_file(path string, rest []string) error {
+	return os.Rename(path, rest[0])
+}
+
+func file_info(a []MalType) (MalType, error) {
+	path, e := arg_string("file-info", a[0])
+	if e != nil {
+		return nil, e
+	}
+	info, e := os.Stat(path)
+	if e != nil {
+		return nil, io_error("file-info", e)
+	}
+	return HashMap{map[string]MalType{
+		"\u029ename":       info.Name(),
+		"\u029epath":       path,
+		"\u029esize":       int(info.Size()),
+		"\u029edirectory?": info.IsDir(),
+		"\u029emode":       info.Mode().String(),
+		"\u029emodified":   int(info.ModTime().UnixNano() / 1e6),
+	}, nil}, nil
+}
+
+// Handles on files. reader and writer open them, close releases them
+// and with-open closes them when its body exits.
+
+func file_reader(a []MalType) (MalType, error) {
+	path, e := arg_string("reader", a[0])
+	if e != nil {
+		return nil, e
+	}
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, io_error("reader", e)
+	}
+	return &Handle{path, bufio.NewReader(f), nil, f}, nil
+}
+
+// (writer path & opts) takes the options of spit
+func file_writer(a []MalType) (MalType, error) {
+	if len(a) < 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 1)", len(a))
+	}
+	path, e := arg_string("writer", a[0])
+	if e != nil {
+		return nil, e
+	}
+	append_, e := append_option("writer", a[1:])
+	if e != nil {
+		return nil, e
+	}
+	f, e := open_for_writing("writer", path, append_)
+	if e != nil {
+		return nil, e
+	}
+	return &Handle{path, nil, f, f}, nil
+}
+
+func arg_handle(name string, a MalType) (*Handle, error) {
+	h, ok := a.(*Handle)
+	if !ok {
+		return nil, NewTypeError("%s: expected handle, got %s", name, TypeName(a))
+	}
+	return h, nil
+}
+
+// close_handle closes a handle once; the standard streams and string
+// writers have nothing to close
+func close_handle(a []MalType) (MalType, error) {
+	h, e := arg_handle("close", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if h.Closer == nil {
+		return nil, nil
+	}
+	c := h.Closer
+	h.Closer = nil
+	if e = c.Close(); e != nil {
+		return nil, io_error("close", e)
+	}
+	return nil, nil
+}
+
+// next_line returns the next line of r without its line ending, and
+// false at the end of the input
+func next_line(name string, r *bufio.Reader) (string, bool, error) {
+	line, e := r.ReadString('\n')
+	if e == io.EOF {
+		return line, line != "", nil
+	} else if e != nil {
+		return "", false, io_error(name, e)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), true, nil
+}
+
+func arg_reader(name string, a MalType) (*bufio.Reader, error) {
+	h, e := arg_handle(name, a)
+	if e != nil {
+		return nil, e
+	}
+	if h.Reader == nil {
+		return nil, NewTypeError("%s: %s is not readable", name, printer.Pr_str(h, true))
+	}
+	return h.Reader, nil
+}
+
+// read_handle_line returns the next line of a reader, or nil at its end
+func read_handle_line(a []MalType) (MalType, error) {
+	r, e := arg_reader("read-line", a[0])
+	if e != nil {
+		return nil, e
+	}
+	line, ok, e := next_line("read-line", r)
+	if !ok || e != nil {
+		return nil, e
+	}
+	return line, nil
+}
+
+// (line-seq file) returns the remaining lines of a reader, or those of
+// the file at a path, or nil if there are none
+func line_seq(a []MalType) (MalType, error) {
+	var r *bufio.Reader
+	if path, ok := a[0].(string); ok && !Keyword_Q(path) {
+		f, e := os.Open(path)
+		if e != nil {
+			return nil, io_error("line-seq", e)
+		}
+		defer f.Close()
+		r = bufio.NewReader(f)
+	} else {
+		var e error
+		if r, e = arg_reader("line-seq", a[0]); e != nil {
+			return nil, e
+		}
+	}
+	lines := []MalType{}
+	for {
+		line, ok, e := next_line("line-seq", r)
+		if e != nil {
+			return nil, e
+		}
+		if !ok {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return List{lines, nil}, nil
+}
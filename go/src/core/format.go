@@ -0,0 +1,101 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// Formatting with Go verbs. A directive is % followed by flags among
+// "-+# 0", a width, a precision after "." and a verb: s prints a value
+// as str would, v as pr-str would, d an integer, x or X an integer or
+// the bytes of a string in hex, f, e or g a number and %% a percent
+// sign.
+
+// format_arg converts the argument of a directive for fmt.Sprintf
+func format_arg(verb byte, arg MalType) (interface{}, error) {
+	switch verb {
+	case 's':
+		return printer.Pr_str(arg, false), nil
+	case 'v':
+		return printer.Pr_str(arg, true), nil
+	case 'd':
+		return arg_number("format", arg)
+	case 'x', 'X':
+		if str, ok := arg.(string); ok && !Keyword_Q(str) {
+			return str, nil
+		}
+		return arg_number("format", arg)
+	case 'f', 'e', 'g':
+		if _, e := arg_num("format", arg); e != nil {
+			return nil, e
+		}
+		return to_float(arg), nil
+	}
+	return nil, fmt.Errorf("format: unknown verb %%%c", verb)
+}
+
+func format(a []MalType) (MalType, error) {
+	if len(a) < 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 1)", len(a))
+	}
+	fmtstr, e := arg_string("format", a[0])
+	if e != nil {
+		return nil, e
+	}
+	args := a[1:]
+	var b strings.Builder
+	used := 0
+	for i := 0; i < len(fmtstr); i += 1 {
+		if fmtstr[i] != '%' {
+			b.WriteByte(fmtstr[i])
+			continue
+		}
+		start := i
+		i += 1
+		for i < len(fmtstr) && strings.IndexByte("-+# 0", fmtstr[i]) >= 0 {
+			i += 1
+		}
+		for i < len(fmtstr) && (fmtstr[i] >= '0' && fmtstr[i] <= '9' || fmtstr[i] == '.') {
+			i += 1
+		}
+		if i == len(fmtstr) {
+			return nil, fmt.Errorf("format: incomplete directive %s", fmtstr[start:])
+		}
+		if fmtstr[i] == '%' && i == start+1 {
+			b.WriteByte('%')
+			continue
+		}
+		if used == len(args) {
+			return nil, NewArityError("format: missing argument for %s", fmtstr[start:i+1])
+		}
+		arg, e := format_arg(fmtstr[i], args[used])
+		if e != nil {
+			return nil, e
+		}
+		used += 1
+		fmt.Fprintf(&b, fmtstr[start:i+1], arg)
+	}
+	if used < len(args) {
+		return nil, NewArityError("format: %d arguments for %d directives", len(args), used)
+	}
+	return b.String(), nil
+}
+
+// printf writes a formatted string to *out*, without a newline
+func printf(ec *EvalContext, a []MalType) (MalType, error) {
+	str, e := format(a)
+	if e != nil {
+		return nil, e
+	}
+	w, e := out_writer(ec)
+	if e != nil {
+		return nil, e
+	}
+	fmt.Fprint(w, str)
+	return nil, nil
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// JSON. Objects are read as hash-maps, arrays as vectors and numbers as
+// integers when they have no fraction or exponent.
+
+func json_error(name string, e error) error {
+	return TypedError{"json-error", fmt.Errorf("%s: %v", name, e)}
+}
+
+// json_options reads the key value pairs after the fixed arguments,
+// checking that only known options are given
+func json_options(name string, opts []MalType, known ...string) (map[string]MalType, error) {
+	if len(opts)%2 != 0 {
+		return nil, NewArityError("%s: options must be key value pairs", name)
+	}
+	res := map[string]MalType{}
+	for i := 0; i < len(opts); i += 2 {
+		k, _ := opts[i].(string)
+		found := false
+		for _, kn := range known {
+			found = found || k == "\u029e"+kn
+		}
+		if !found {
+			return nil, NewTypeError("%s: unknown option %s", name, printer.Pr_str(opts[i], true))
+		}
+		res[json_key(k)] = opts[i+1]
+	}
+	return res, nil
+}
+
+type json_reader struct {
+	keywordize  bool
+	big_numbers string // float, string or error
+}
+
+func (r json_reader) number(n json.Number) (MalType, error) {
+	s := string(n)
+	if !strings.ContainsAny(s, ".eE") {
+		if i, e := strconv.Atoi(s); e == nil {
+			return i, nil
+		}
+		// too large for an integer
+		switch r.big_numbers {
+		case "string":
+			return s, nil
+		case "error":
+			return nil, json_error("json-parse", fmt.Errorf("number %s out of range", s))
+		}
+	}
+	f, e := strconv.ParseFloat(s, 64)
+	if e != nil {
+		if r.big_numbers == "string" {
+			return s, nil
+		}
+		return nil, json_error("json-parse", fmt.Errorf("number %s out of range", s))
+	}
+	return f, nil
+}
+
+func (r json_reader) value(v interface{}) (MalType, error) {
+	switch tv := v.(type) {
+	case nil, bool, string:
+		return tv, nil
+	case json.Number:
+		return r.number(tv)
+	case []interface{}:
+		vec := make([]MalType, len(tv))
+		for i, x := range tv {
+			var e error
+			if vec[i], e = r.value(x); e != nil {
+				return nil, e
+			}
+		}
+		return Vector{vec, nil}, nil
+	case map[string]interface{}:
+		m := map[string]MalType{}
+		for k, x := range tv {
+			val, e := r.value(x)
+			if e != nil {
+				return nil, e
+			}
+			if r.keywordize {
+				k = "\u029e" + k
+			}
+			m[k] = val
+		}
+		return HashMap{m, nil}, nil
+	}
+	return nil, fmt.Errorf("json-parse: unexpected %T", v)
+}
+
+// (json-parse str & opts) with options :keywordize (read object keys
+// as keywords) and :big-numbers (:float, :string or :error, for numbers
+// that do not fit an integer or a float)
+func json_parse(a []MalType) (MalType, error) {
+	if len(a) < 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 1)", len(a))
+	}
+	str, e := arg_string("json-parse", a[0])
+	if e != nil {
+		return nil, e
+	}
+	opts, e := json_options("json-parse", a[1:], "keywordize", "big-numbers")
+	if e != nil {
+		return nil, e
+	}
+	r := json_reader{truthy(opts["keywordize"]), "float"}
+	if big, ok := opts["big-numbers"]; ok {
+		switch big {
+		case "\u029efloat", "\u029estring", "\u029eerror":
+			r.big_numbers = json_key(big.(string))
+		default:
+			return nil, NewTypeError("json-parse: :big-numbers must be :float, :string or :error")
+		}
+	}
+	dec := json.NewDecoder(strings.NewReader(str))
+	dec.UseNumber()
+	var v interface{}
+	if e = dec.Decode(&v); e != nil {
+		return nil, json_error("json-parse", e)
+	}
+	if _, e = dec.Token(); e != io.EOF {
+		return nil, json_error("json-parse", fmt.Errorf("unexpected data after the value"))
+	}
+	return r.value(v)
+}
+
+// json_key returns the name of a string or keyword hash-map key
+func json_key(k string) string {
+	return strings.TrimPrefix(k, "\u029e")
+}
+
+// json_value converts a mal value to one encoding/json can encode.
+// Keywords and symbols are written as their names.
+func json_value(obj MalType) (interface{}, error) {
+	switch tobj := obj.(type) {
+	case nil, bool, int:
+		return tobj, nil
+	case float64:
+		if math.IsNaN(tobj) || math.IsInf(tobj, 0) {
+			return nil, NewTypeError("json-str: cannot encode %s", printer.Pr_str(tobj, true))
+		}
+		return tobj, nil
+	case string:
+		return json_key(tobj), nil
+	case Symbol:
+		return tobj.Val, nil
+	case List, Vector:
+		slc, _ := GetSlice(tobj)
+		arr := make([]interface{}, len(slc))
+		for i, x := range slc {
+			var e error
+			if arr[i], e = json_value(x); e != nil {
+				return nil, e
+			}
+		}
+		return arr, nil
+	case HashMap:
+		m := make(map[string]interface{}, len(tobj.Val))
+		for k, x := range tobj.Val {
+			v, e := json_value(x)
+			if e != nil {
+				return nil, e
+			}
+			m[json_key(k)] = v
+		}
+		return m, nil
+	}
+	return nil, NewTypeError("json-str: cannot encode %s", TypeName(obj))
+}
+
+// (json-str value & opts) with option :pretty (indent nested values).
+// Object keys are written in sorted order.
+func json_str(a []MalType) (MalType, error) {
+	if len(a) < 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 1)", len(a))
+	}
+	opts, e := json_options("json-str", a[1:], "pretty")
+	if e != nil {
+		return nil, e
+	}
+	v, e := json_value(a[0])
+	if e != nil {
+		return nil, e
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if truthy(opts["pretty"]) {
+		enc.SetIndent("", "  ")
+	}
+	if e = enc.Encode(v); e != nil {
+		return nil, json_error("json-str", e)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,368 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+import (
+	. "types"
+)
+
+// Arithmetic. Operations on two ints give an int; if either operand is
+// a float the operation is done in floating point.
+
+func arg_num(name string, a MalType) (MalType, error) {
+	if !Number_Q(a) {
+		return nil, NewTypeError("%s: expected number, got %s", name, TypeName(a))
+	}
+	return a, nil
+}
+
+func to_float(n MalType) float64 {
+	if i, ok := n.(int); ok {
+		return float64(i)
+	}
+	return n.(float64)
+}
+
+type num_op struct {
+	ints   func(x, y int) (MalType, error)
+	floats func(x, y float64) (MalType, error)
+}
+
+func (op num_op) apply(x, y MalType) (MalType, error) {
+	xi, x_int := x.(int)
+	yi, y_int := y.(int)
+	if x_int && y_int {
+		return op.ints(xi, yi)
+	}
+	return op.floats(to_float(x), to_float(y))
+}
+
+var errDivideByZero = TypedError{"arithmetic-error", errors.New("division by zero")}
+
+var add = num_op{
+	func(x, y int) (MalType, error) { return x + y, nil },
+	func(x, y float64) (MalType, error) { return x + y, nil },
+}
+
+var subtract = num_op{
+	func(x, y int) (MalType, error) { return x - y, nil },
+	func(x, y float64) (MalType, error) { return x - y, nil },
+}
+
+var multiply = num_op{
+	func(x, y int) (MalType, error) { return x * y, nil },
+	func(x, y float64) (MalType, error) { return x * y, nil },
+}
+
+// division of ints truncates
+var divide = num_op{
+	func(x, y int) (MalType, error) {
+		if y == 0 {
+			return nil, errDivideByZero
+		}
+		return x / y, nil
+	},
+	func(x, y float64) (MalType, error) { return x / y, nil },
+}
+
+var quot = num_op{
+	divide.ints,
+	func(x, y float64) (MalType, error) { return math.Trunc(x / y), nil },
+}
+
+// rem has the sign of the dividend
+var rem = num_op{
+	func(x, y int) (MalType, error) {
+		if y == 0 {
+			return nil, errDivideByZero
+		}
+		return x % y, nil
+	},
+	func(x, y float64) (MalType, error) { return math.Mod(x, y), nil },
+}
+
+// mod has the sign of the divisor
+var mod = num_op{
+	func(x, y int) (MalType, error) {
+		if y == 0 {
+			return nil, errDivideByZero
+		}
+		r := x % y
+		if r != 0 && (r < 0) != (y < 0) {
+			r += y
+		}
+		return r, nil
+	},
+	func(x, y float64) (MalType, error) {
+		r := math.Mod(x, y)
+		if r != 0 && (r < 0) != (y < 0) {
+			r += y
+		}
+		return r, nil
+	},
+}
+
+// arith folds op over its arguments from the left. A single argument x
+// gives (op unit x), so (- x) negates; with no arguments the result is
+// unit if empty_ok and an error otherwise.
+func arith(name string, unit MalType, empty_ok bool, op num_op) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		if len(a) == 0 {
+			if !empty_ok {
+				return nil, NewArityError("wrong number of arguments (0 instead of at least 1)")
+			}
+			return unit, nil
+		}
+		acc := unit
+		if len(a) > 1 {
+			acc, a = a[0], a[1:]
+			if _, e := arg_num(name, acc); e != nil {
+				return nil, e
+			}
+		}
+		for _, x := range a {
+			if _, e := arg_num(name, x); e != nil {
+				return nil, e
+			}
+			var e error
+			if acc, e = op.apply(acc, x); e != nil {
+				return nil, TypedError{"arithmetic-error", errors.New(name + ": " + e.Error())}
+			}
+		}
+		return acc, nil
+	}
+}
+
+// binary_arith applies op to exactly two numbers
+func binary_arith(name string, op num_op) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		for _, x := range a {
+			if _, e := arg_num(name, x); e != nil {
+				return nil, e
+			}
+		}
+		res, e := op.apply(a[0], a[1])
+		if e != nil {
+			return nil, TypedError{"arithmetic-error", errors.New(name + ": " + e.Error())}
+		}
+		return res, nil
+	}
+}
+
+// num_compare returns -1, 0 or 1 as x is less than, equal to or greater
+// than y, and false if they are unordered (NaN)
+func num_compare(x, y MalType) (int, bool) {
+	xi, x_int := x.(int)
+	yi, y_int := y.(int)
+	if x_int && y_int {
+		switch {
+		case xi < yi:
+			return -1, true
+		case xi > yi:
+			return 1, true
+		}
+		return 0, true
+	}
+	xf, yf := to_float(x), to_float(y)
+	switch {
+	case xf < yf:
+		return -1, true
+	case xf > yf:
+		return 1, true
+	case xf == yf:
+		return 0, true
+	}
+	return 0, false
+}
+
+// compare_chain checks that each argument is related to the next by
+// test, as in (< a b c)
+func compare_chain(name string, test func(c int) bool) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		if len(a) == 0 {
+			return nil, NewArityError("wrong number of arguments (0 instead of at least 1)")
+		}
+		for _, x := range a {
+			if _, e := arg_num(name, x); e != nil {
+				return nil, e
+			}
+		}
+		for i := 1; i < len(a); i += 1 {
+			if c, ok := num_compare(a[i-1], a[i]); !ok || !test(c) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// extremum returns the argument x for which better(x, y) holds against
+// every other y
+func extremum(name string, better func(c int) bool) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		if len(a) == 0 {
+			return nil, NewArityError("wrong number of arguments (0 instead of at least 1)")
+		}
+		res, e := arg_num(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		for _, x := range a[1:] {
+			if _, e := arg_num(name, x); e != nil {
+				return nil, e
+			}
+			if c, ok := num_compare(x, res); !ok {
+				return math.NaN(), nil
+			} else if better(c) {
+				res = x
+			}
+		}
+		return res, nil
+	}
+}
+
+func float_Q(a MalType) bool {
+	_, ok := a.(float64)
+	return ok
+}
+
+func inc(a []MalType) (MalType, error) {
+	n, e := arg_num("inc", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return add.apply(n, 1)
+}
+
+func dec(a []MalType) (MalType, error) {
+	n, e := arg_num("dec", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return subtract.apply(n, 1)
+}
+
+func abs(a []MalType) (MalType, error) {
+	n, e := arg_num("abs", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if i, ok := n.(int); ok {
+		if i < 0 {
+			return -i, nil
+		}
+		return i, nil
+	}
+	return math.Abs(n.(float64)), nil
+}
+
+// pow raises ints to non-negative int powers exactly, and works in
+// floating point otherwise
+func pow(a []MalType) (MalType, error) {
+	for _, x := range a {
+		if _, e := arg_num("pow", x); e != nil {
+			return nil, e
+		}
+	}
+	base, base_int := a[0].(int)
+	exp, exp_int := a[1].(int)
+	if !base_int || !exp_int || exp < 0 {
+		return math.Pow(to_float(a[0]), to_float(a[1])), nil
+	}
+	res := 1
+	for ; exp > 0; exp >>= 1 {
+		if exp&1 == 1 {
+			res *= base
+		}
+		base *= base
+	}
+	return res, nil
+}
+
+// float_fn lifts a function on floats into a core function
+func float_fn(name string, f func(float64) float64) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		n, e := arg_num(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		return f(to_float(n)), nil
+	}
+}
+
+// to_int lifts a rounding function into a core function returning an
+// int
+func to_int(name string, f func(float64) float64) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		n, e := arg_num(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		if i, ok := n.(int); ok {
+			return i, nil
+		}
+		x := f(n.(float64))
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, TypedError{"arithmetic-error", errors.New(name + ": not a finite number")}
+		}
+		if x < math.MinInt64 || x >= math.MaxInt64 {
+			// float64(math.MaxInt64) rounds up to 2^63, which is out of range
+			return nil, TypedError{"arithmetic-error", errors.New(name + ": out of integer range")}
+		}
+		return int(x), nil
+	}
+}
+
+func atan2(a []MalType) (MalType, error) {
+	for _, x := range a {
+		if _, e := arg_num("atan2", x); e != nil {
+			return nil, e
+		}
+	}
+	return math.Atan2(to_float(a[0]), to_float(a[1])), nil
+}
+
+// Random numbers
+
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// (rand) is a float in [0, 1) and (rand n) one in [0, n)
+func random(a []MalType) (MalType, error) {
+	if len(a) > 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 0 or 1)", len(a))
+	}
+	if len(a) == 0 {
+		return rng.Float64(), nil
+	}
+	n, e := arg_num("rand", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return rng.Float64() * to_float(n), nil
+}
+
+// (rand-int n) is an int in [0, n)
+func rand_int(a []MalType) (MalType, error) {
+	n, e := arg_number("rand-int", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if n <= 0 {
+		return nil, NewTypeError("rand-int: bound must be positive")
+	}
+	return rng.Intn(n), nil
+}
+
+func rand_seed(a []MalType) (MalType, error) {
+	seed, e := arg_number("rand-seed!", a[0])
+	if e != nil {
+		return nil, e
+	}
+	rng.Seed(int64(seed))
+	return nil, nil
+}
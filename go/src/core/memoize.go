@@ -0,0 +1,182 @@
+package core
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+import (
+	. "types"
+)
+
+// Delays
+
+func delay(a []MalType) (MalType, error) {
+	if is_fn, _ := fn_q(a); is_fn != true {
+		return nil, NewTypeError("delay*: expected function, got %s", TypeName(a[0]))
+	}
+	return NewDelay(a[0]), nil
+}
+
+func force(ec *EvalContext, a []MalType) (MalType, error) {
+	if d, ok := a[0].(*Delay); ok {
+		return d.Force(ec)
+	}
+	return a[0], nil
+}
+
+func realized_Q(a []MalType) (MalType, error) {
+	d, ok := a[0].(*Delay)
+	if !ok {
+		return nil, NewTypeError("realized?: expected delay, got %s", TypeName(a[0]))
+	}
+	return d.Realized(), nil
+}
+
+// Memoization
+
+// hash_value hashes obj consistently with Equal_Q: lists and vectors
+// with the same elements hash alike and hash-maps hash independently of
+// their iteration order. Values without a structural hash, such as
+// functions, only hash their type and are told apart by Equal_Q.
+func hash_value(obj MalType) uint64 {
+	h := fnv.New64a()
+	switch tobj := obj.(type) {
+	case nil:
+		h.Write([]byte("nil"))
+	case bool:
+		h.Write([]byte(strconv.FormatBool(tobj)))
+	case int:
+		h.Write([]byte("n" + strconv.Itoa(tobj)))
+	case float64:
+		if tobj == 0 {
+			tobj = 0 // -0.0 equals 0.0
+		}
+		h.Write([]byte("f" + strconv.FormatUint(math.Float64bits(tobj), 36)))
+	case string:
+		h.Write([]byte("s" + tobj))
+	case Symbol:
+		h.Write([]byte("y" + tobj.Val))
+	case Instant:
+		h.Write([]byte("i" + strconv.FormatInt(tobj.T.UnixNano(), 36)))
+	case List, Vector:
+		slc, _ := GetSlice(tobj)
+		h.Write([]byte("l"))
+		for _, x := range slc {
+			h.Write([]byte(strconv.FormatUint(hash_value(x), 36) + " "))
+		}
+	case HashMap:
+		keys := make([]string, 0, len(tobj.Val))
+		for k := range tobj.Val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		h.Write([]byte("m"))
+		for _, k := range keys {
+			h.Write([]byte(k + " " + strconv.FormatUint(hash_value(tobj.Val[k]), 36) + " "))
+		}
+	default:
+		h.Write([]byte(TypeName(obj)))
+	}
+	return h.Sum64()
+}
+
+type memo_entry struct {
+	hash uint64
+	args List
+	val  MalType
+}
+
+// memo_cache maps argument lists to results, evicting the least
+// recently used entry beyond limit entries (0 for no limit)
+type memo_cache struct {
+	mu      sync.Mutex
+	limit   int
+	buckets map[uint64][]*list.Element
+	order   *list.List // most recently used first
+}
+
+func (c *memo_cache) lookup(h uint64, args List) (MalType, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.buckets[h] {
+		ent := el.Value.(*memo_entry)
+		if Equal_Q(ent.args, args) {
+			c.order.MoveToFront(el)
+			return ent.val, true
+		}
+	}
+	return nil, false
+}
+
+func (c *memo_cache) store(h uint64, args List, val MalType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.buckets[h] {
+		if Equal_Q(el.Value.(*memo_entry).args, args) {
+			// stored by a call made while this one ran
+			return
+		}
+	}
+	c.buckets[h] = append(c.buckets[h], c.order.PushFront(&memo_entry{h, args, val}))
+	if c.limit > 0 && c.order.Len() > c.limit {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *memo_cache) remove(el *list.Element) {
+	ent := c.order.Remove(el).(*memo_entry)
+	bucket := c.buckets[ent.hash]
+	for i, b := range bucket {
+		if b == el {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(c.buckets, ent.hash)
+	} else {
+		c.buckets[ent.hash] = bucket
+	}
+}
+
+// memoize returns a function caching the results of f by argument list,
+// optionally keeping only the given number of most recently used ones.
+// Errors are not cached.
+func memoize(a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	if is_fn, _ := fn_q(a[:1]); is_fn != true {
+		return nil, NewTypeError("memoize: expected function, got %s", TypeName(a[0]))
+	}
+	f := a[0]
+	c := &memo_cache{buckets: map[uint64][]*list.Element{}, order: list.New()}
+	if len(a) == 2 {
+		limit, e := arg_number("memoize", a[1])
+		if e != nil {
+			return nil, e
+		}
+		if limit <= 0 {
+			return nil, NewTypeError("memoize: size must be positive")
+		}
+		c.limit = limit
+	}
+	return Func{func(ec *EvalContext, args []MalType) (MalType, error) {
+		key := List{append([]MalType{}, args...), nil}
+		h := hash_value(key)
+		if val, ok := c.lookup(h, key); ok {
+			return val, nil
+		}
+		val, e := Apply(ec, f, args)
+		if e != nil {
+			return nil, e
+		}
+		c.store(h, key, val)
+		return val, nil
+	}, nil}, nil
+}
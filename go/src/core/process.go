@@ -0,0 +1,306 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// External processes and the environment
+
+// command builds the command given as a program, its arguments and
+// options :in (a string to use as standard input), :dir (the working
+// directory) and :env (a map of variables added to the environment).
+// It returns the :in string, if any.
+func command(name string, ctx context.Context, a []MalType) (*exec.Cmd, *string, error) {
+	n := 0
+	for n < len(a) && !Keyword_Q(a[n]) {
+		n += 1
+	}
+	if n == 0 {
+		return nil, nil, NewArityError("%s: expected a program to run", name)
+	}
+	args, e := string_args(name, a[:n])
+	if e != nil {
+		return nil, nil, e
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var in *string
+	opts := a[n:]
+	if len(opts)%2 != 0 {
+		return nil, nil, NewArityError("%s: options must be key value pairs", name)
+	}
+	for i := 0; i < len(opts); i += 2 {
+		switch opts[i] {
+		case "\u029ein":
+			s, e := arg_string(name, opts[i+1])
+			if e != nil {
+				return nil, nil, e
+			}
+			in = &s
+		case "\u029edir":
+			if cmd.Dir, e = arg_string(name, opts[i+1]); e != nil {
+				return nil, nil, e
+			}
+		case "\u029eenv":
+			if cmd.Env, e = environment(name, opts[i+1]); e != nil {
+				return nil, nil, e
+			}
+		default:
+			return nil, nil, NewTypeError("%s: unknown option %s", name, printer.Pr_str(opts[i], true))
+		}
+	}
+	return cmd, in, nil
+}
+
+// environment returns the current environment with the variables in
+// the map vars added, by name or keyword
+func environment(name string, vars MalType) ([]string, error) {
+	hm, ok := vars.(HashMap)
+	if !ok {
+		return nil, NewTypeError("%s: expected hash-map for :env, got %s", name, TypeName(vars))
+	}
+	keys := make([]string, 0, len(hm.Val))
+	for k := range hm.Val {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := os.Environ()
+	for _, k := range keys {
+		// later entries take precedence
+		env = append(env, strings.TrimPrefix(k, "\u029e")+"="+printer.Pr_str(hm.Val[k], false))
+	}
+	return env, nil
+}
+
+// eval_context returns the context interrupting the evaluation ec, so
+// that interrupting it also kills the commands it runs
+func eval_context(ec *EvalContext) context.Context {
+	if ec != nil && ec.Context != nil {
+		return ec.Context
+	}
+	return context.Background()
+}
+
+// (sh program arg... opt...) runs a command to completion and returns
+// {:exit status :out stdout :err stderr}
+func sh(ec *EvalContext, a []MalType) (MalType, error) {
+	ctx := eval_context(ec)
+	cmd, in, e := command("sh", ctx, a)
+	if e != nil {
+		return nil, e
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if in != nil {
+		cmd.Stdin = strings.NewReader(*in)
+	}
+	e = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ec.Check()
+	}
+	if e != nil && !errors.As(e, new(*exec.ExitError)) {
+		return nil, io_error("sh", e)
+	}
+	return HashMap{map[string]MalType{
+		"\u029eexit": cmd.ProcessState.ExitCode(),
+		"\u029eout":  stdout.String(),
+		"\u029eerr":  stderr.String(),
+	}, nil}, nil
+}
+
+// (process program arg... opt...) starts a command with its output
+// readable from process-out and process-err and, unless :in is given,
+// its input writable to process-in. The process outlives the evaluation
+// starting it, unless that is interrupted or runs out of time.
+func process(ec *EvalContext, a []MalType) (MalType, error) {
+	cmd, in, e := command("process", context.Background(), a)
+	if e != nil {
+		return nil, e
+	}
+	p := &Process{cmd, nil, nil, nil}
+	if in != nil {
+		cmd.Stdin = strings.NewReader(*in)
+	} else {
+		w, e := cmd.StdinPipe()
+		if e != nil {
+			return nil, io_error("process", e)
+		}
+		p.In = &Handle{cmd.Args[0] + " stdin", nil, w, w}
+	}
+	stdout, e := cmd.StdoutPipe()
+	if e != nil {
+		return nil, io_error("process", e)
+	}
+	p.Out = &Handle{cmd.Args[0] + " stdout", bufio.NewReader(stdout), nil, stdout}
+	stderr, e := cmd.StderrPipe()
+	if e != nil {
+		return nil, io_error("process", e)
+	}
+	p.Err = &Handle{cmd.Args[0] + " stderr", bufio.NewReader(stderr), nil, stderr}
+	if e = cmd.Start(); e != nil {
+		return nil, io_error("process", e)
+	}
+	ctx := eval_context(ec)
+	context.AfterFunc(ctx, func() {
+		// an evaluation that ends normally is cancelled too
+		if !errors.Is(context.Cause(ctx), context.Canceled) {
+			cmd.Process.Kill()
+		}
+	})
+	return p, nil
+}
+
+func arg_process(name string, a MalType) (*Process, error) {
+	p, ok := a.(*Process)
+	if !ok {
+		return nil, NewTypeError("%s: expected process, got %s", name, TypeName(a))
+	}
+	return p, nil
+}
+
+// process_stream returns a function of a process returning one of its
+// stream handles
+func process_stream(name string, stream func(p *Process) *Handle) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		p, e := arg_process(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		if h := stream(p); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	}
+}
+
+// process_wait closes the input of a process and returns its exit
+// status once it has exited. The output it has not read yet is read
+// meanwhile, so that the process does not block on a full pipe, and
+// can still be read from process-out and process-err.
+func process_wait(a []MalType) (MalType, error) {
+	p, e := arg_process("process-wait", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if p.Cmd.ProcessState == nil {
+		if p.In != nil {
+			close_handle([]MalType{p.In})
+		}
+		var wg sync.WaitGroup
+		for _, h := range []*Handle{p.Out, p.Err} {
+			if h.Closer == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rest, _ := io.ReadAll(h.Reader)
+				h.Reader = bufio.NewReader(bytes.NewReader(rest))
+			}()
+		}
+		wg.Wait()
+		if e = reap(p); e != nil && !errors.As(e, new(*exec.ExitError)) {
+			return nil, io_error("process-wait", e)
+		}
+	}
+	return p.Cmd.ProcessState.ExitCode(), nil
+}
+
+func process_kill(a []MalType) (MalType, error) {
+	p, e := arg_process("process-kill", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if p.Cmd.ProcessState == nil {
+		if e = p.Cmd.Process.Kill(); e != nil && !errors.Is(e, os.ErrProcessDone) {
+			return nil, io_error("process-kill", e)
+		}
+		reap(p)
+	}
+	return nil, nil
+}
+
+// reap waits for a process to exit and releases its resources. Its
+// pipes are closed then, which closing their handles need not do.
+func reap(p *Process) error {
+	e := p.Cmd.Wait()
+	for _, h := range []*Handle{p.In, p.Out, p.Err} {
+		if h != nil {
+			h.Closer = nil
+		}
+	}
+	return e
+}
+
+// (getenv) returns the whole environment as a map, (getenv name) the
+// value of a variable or nil if it is not set
+func getenv(a []MalType) (MalType, error) {
+	if len(a) > 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 0 or 1)", len(a))
+	}
+	if len(a) == 1 {
+		name, e := arg_string("getenv", a[0])
+		if e != nil {
+			return nil, e
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return val, nil
+		}
+		return nil, nil
+	}
+	env := map[string]MalType{}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return HashMap{env, nil}, nil
+}
+
+// (setenv name value) sets a variable, or unsets it if value is nil
+func setenv(a []MalType) (MalType, error) {
+	name, e := arg_string("setenv", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if a[1] == nil {
+		e = os.Unsetenv(name)
+	} else {
+		e = os.Setenv(name, printer.Pr_str(a[1], false))
+	}
+	if e != nil {
+		return nil, io_error("setenv", e)
+	}
+	return nil, nil
+}
+
+// Exit is called by exit to terminate the process with a status
+var Exit = os.Exit
+
+func exit(a []MalType) (MalType, error) {
+	if len(a) > 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 0 or 1)", len(a))
+	}
+	status := 0
+	if len(a) == 1 {
+		var e error
+		if status, e = arg_number("exit", a[0]); e != nil {
+			return nil, e
+		}
+	}
+	Exit(status)
+	return nil, nil
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+import (
+	. "types"
+)
+
+// Regular expressions. A match of a pattern without groups is the
+// matched string; with groups it is a vector of the match followed by
+// each group, nil for groups that did not participate.
+
+func arg_regex(name string, a MalType) (Regex, error) {
+	re, ok := a.(Regex)
+	if !ok {
+		return Regex{}, NewTypeError("%s: expected regex, got %s", name, TypeName(a))
+	}
+	return re, nil
+}
+
+// regex_args checks the (re s) arguments of the re- functions
+func regex_args(name string, a []MalType) (Regex, string, error) {
+	re, e := arg_regex(name, a[0])
+	if e != nil {
+		return Regex{}, "", e
+	}
+	s, e := arg_string(name, a[1])
+	if e != nil {
+		return Regex{}, "", e
+	}
+	return re, s, nil
+}
+
+// match_value returns the match of re at loc, a submatch index slice,
+// in s
+func match_value(re *regexp.Regexp, s string, loc []int) MalType {
+	if re.NumSubexp() == 0 {
+		return s[loc[0]:loc[1]]
+	}
+	groups := make([]MalType, len(loc)/2)
+	for i := range groups {
+		if loc[2*i] >= 0 {
+			groups[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return Vector{groups, nil}
+}
+
+func re_pattern(a []MalType) (MalType, error) {
+	if Regex_Q(a[0]) {
+		return a[0], nil
+	}
+	pattern, e := arg_string("re-pattern", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return NewRegex(pattern)
+}
+
+func re_find(a []MalType) (MalType, error) {
+	re, s, e := regex_args("re-find", a)
+	if e != nil {
+		return nil, e
+	}
+	loc := re.Re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, nil
+	}
+	return match_value(re.Re, s, loc), nil
+}
+
+// re-matches only matches the whole string
+func re_matches(a []MalType) (MalType, error) {
+	re, s, e := regex_args("re-matches", a)
+	if e != nil {
+		return nil, e
+	}
+	loc := re.Anchored.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, nil
+	}
+	return match_value(re.Anchored, s, loc), nil
+}
+
+func re_seq(ec *EvalContext, a []MalType) (MalType, error) {
+	re, s, e := regex_args("re-seq", a)
+	if e != nil {
+		return nil, e
+	}
+	locs := re.Re.FindAllStringSubmatchIndex(s, -1)
+	if len(locs) == 0 {
+		return nil, nil
+	}
+	if e := check_alloc(ec, len(locs)); e != nil {
+		return nil, e
+	}
+	matches := make([]MalType, len(locs))
+	for i, loc := range locs {
+		matches[i] = match_value(re.Re, s, loc)
+	}
+	return List{matches, nil}, nil
+}
+
+// re-groups returns the named groups of the first match as a hash-map
+// keyed by keyword
+func re_groups(a []MalType) (MalType, error) {
+	re, s, e := regex_args("re-groups", a)
+	if e != nil {
+		return nil, e
+	}
+	loc := re.Re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, nil
+	}
+	groups := map[string]MalType{}
+	for i, name := range re.Re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if loc[2*i] >= 0 {
+			groups["\u029e"+name] = s[loc[2*i]:loc[2*i+1]]
+		} else {
+			groups["\u029e"+name] = nil
+		}
+	}
+	return HashMap{groups, nil}, nil
+}
+
+// regex_replace replaces the matches of re in s by repl, a string in
+// which $1 or ${name} expand to groups, or a function called with each
+// match
+func regex_replace(ec *EvalContext, re Regex, s string, repl MalType) (MalType, error) {
+	if tmpl, ok := repl.(string); ok && !Keyword_Q(tmpl) {
+		return re.Re.ReplaceAllString(s, tmpl), nil
+	}
+	if is_fn, _ := fn_q([]MalType{repl}); is_fn != true {
+		return nil, NewTypeError("replace: expected string or function, got %s", TypeName(repl))
+	}
+	var sb strings.Builder
+	last := 0
+	for _, loc := range re.Re.FindAllStringSubmatchIndex(s, -1) {
+		res, e := Apply(ec, repl, []MalType{match_value(re.Re, s, loc)})
+		if e != nil {
+			return nil, e
+		}
+		str, ok := res.(string)
+		if !ok || Keyword_Q(str) {
+			return nil, NewTypeError("replace: function returned %s instead of string", TypeName(res))
+		}
+		sb.WriteString(s[last:loc[0]])
+		sb.WriteString(str)
+		last = loc[1]
+	}
+	sb.WriteString(s[last:])
+	return sb.String(), nil
+}
@@ -0,0 +1,663 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+import (
+	. "types"
+)
+
+// Sequence library. Collections may be lists, vectors, nil, strings (as
+// their characters) or hash-maps (as [key value] vectors in key order).
+
+func arg_seq(name string, a MalType) ([]MalType, error) {
+	switch coll := a.(type) {
+	case List:
+		return coll.Val, nil
+	case Vector:
+		return coll.Val, nil
+	case nil:
+		return nil, nil
+	case string:
+		if !Keyword_Q(coll) {
+			chars := []MalType{}
+			for _, ch := range strings.Split(coll, "") {
+				chars = append(chars, ch)
+			}
+			return chars, nil
+		}
+	case HashMap:
+		keys := make([]string, 0, len(coll.Val))
+		for k := range coll.Val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		entries := make([]MalType, len(keys))
+		for i, k := range keys {
+			entries[i] = Vector{[]MalType{k, coll.Val[k]}, nil}
+		}
+		return entries, nil
+	}
+	return nil, NewTypeError("%s: expected collection, got %s", name, TypeName(a))
+}
+
+// arg_key checks a value used as a hash-map key
+func arg_key(name string, a MalType) (string, error) {
+	k, ok := a.(string)
+	if !ok {
+		return "", NewTypeError("%s: hash-map keys must be strings or keywords, got %s", name, TypeName(a))
+	}
+	return k, nil
+}
+
+func truthy(x MalType) bool {
+	return x != nil && x != false
+}
+
+func call_pred(ec *EvalContext, f MalType, x MalType) (bool, error) {
+	res, e := Apply(ec, f, []MalType{x})
+	if e != nil {
+		return false, e
+	}
+	return truthy(res), nil
+}
+
+// keep returns the elements of coll for which pred is want
+func keep(name string, want bool) func(*EvalContext, []MalType) (MalType, error) {
+	return func(ec *EvalContext, a []MalType) (MalType, error) {
+		slc, e := arg_seq(name, a[1])
+		if e != nil {
+			return nil, e
+		}
+		res := []MalType{}
+		for _, x := range slc {
+			ok, e := call_pred(ec, a[0], x)
+			if e != nil {
+				return nil, e
+			}
+			if ok == want {
+				res = append(res, x)
+			}
+		}
+		return List{res, nil}, nil
+	}
+}
+
+// (reduce f coll) and (reduce f init coll)
+func reduce(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	slc, e := arg_seq("reduce", a[len(a)-1])
+	if e != nil {
+		return nil, e
+	}
+	var acc MalType
+	if len(a) == 3 {
+		acc = a[1]
+	} else if len(slc) == 0 {
+		return Apply(ec, a[0], []MalType{})
+	} else {
+		acc, slc = slc[0], slc[1:]
+	}
+	for _, x := range slc {
+		if acc, e = Apply(ec, a[0], []MalType{acc, x}); e != nil {
+			return nil, e
+		}
+	}
+	return acc, nil
+}
+
+// (reduce-kv f init coll) calls f with the accumulator, each key and
+// its value; the keys of a sequence are the indices
+func reduce_kv(ec *EvalContext, a []MalType) (MalType, error) {
+	acc := a[1]
+	if hm, ok := a[2].(HashMap); ok {
+		slc, _ := arg_seq("reduce-kv", hm)
+		for _, x := range slc {
+			kv := x.(Vector).Val
+			var e error
+			if acc, e = Apply(ec, a[0], []MalType{acc, kv[0], kv[1]}); e != nil {
+				return nil, e
+			}
+		}
+		return acc, nil
+	}
+	slc, e := arg_seq("reduce-kv", a[2])
+	if e != nil {
+		return nil, e
+	}
+	for i, x := range slc {
+		if acc, e = Apply(ec, a[0], []MalType{acc, i, x}); e != nil {
+			return nil, e
+		}
+	}
+	return acc, nil
+}
+
+// count_arg checks the number of elements argument of take and drop,
+// clamped to the length of the collection
+func count_arg(name string, n MalType, slc []MalType) (int, error) {
+	i, e := arg_number(name, n)
+	if e != nil {
+		return 0, e
+	}
+	if i < 0 {
+		return 0, nil
+	}
+	if i > len(slc) {
+		return len(slc), nil
+	}
+	return i, nil
+}
+
+func take(a []MalType) (MalType, error) {
+	slc, e := arg_seq("take", a[1])
+	if e != nil {
+		return nil, e
+	}
+	n, e := count_arg("take", a[0], slc)
+	if e != nil {
+		return nil, e
+	}
+	return List{slc[:n:n], nil}, nil
+}
+
+func drop(a []MalType) (MalType, error) {
+	slc, e := arg_seq("drop", a[1])
+	if e != nil {
+		return nil, e
+	}
+	n, e := count_arg("drop", a[0], slc)
+	if e != nil {
+		return nil, e
+	}
+	return List{slc[n:], nil}, nil
+}
+
+// (partition n coll) and (partition n step coll) return lists of n
+// elements starting every step elements, dropping an incomplete last
+// one
+func partition(a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	n, e := arg_number("partition", a[0])
+	if e != nil {
+		return nil, e
+	}
+	step := n
+	if len(a) == 3 {
+		if step, e = arg_number("partition", a[1]); e != nil {
+			return nil, e
+		}
+	}
+	if n <= 0 || step <= 0 {
+		return nil, NewTypeError("partition: size and step must be positive")
+	}
+	slc, e := arg_seq("partition", a[len(a)-1])
+	if e != nil {
+		return nil, e
+	}
+	parts := []MalType{}
+	for i := 0; i+n <= len(slc); i += step {
+		parts = append(parts, List{slc[i : i+n : i+n], nil})
+	}
+	return List{parts, nil}, nil
+}
+
+// partition-by splits coll each time f returns a new value
+func partition_by(ec *EvalContext, a []MalType) (MalType, error) {
+	slc, e := arg_seq("partition-by", a[1])
+	if e != nil {
+		return nil, e
+	}
+	parts := []MalType{}
+	start := 0
+	var last MalType
+	for i, x := range slc {
+		v, e := Apply(ec, a[0], []MalType{x})
+		if e != nil {
+			return nil, e
+		}
+		if i > 0 && !Equal_Q(v, last) {
+			parts = append(parts, List{slc[start:i:i], nil})
+			start = i
+		}
+		last = v
+	}
+	if start < len(slc) {
+		parts = append(parts, List{slc[start:], nil})
+	}
+	return List{parts, nil}, nil
+}
+
+// compare_values orders numbers numerically, strings, keywords and
+// symbols lexically, booleans false first, instants chronologically and
+// sequences element by element. nil sorts before everything else.
+func compare_values(x, y MalType) (int, error) {
+	if x == nil || y == nil {
+		switch {
+		case x == nil && y == nil:
+			return 0, nil
+		case x == nil:
+			return -1, nil
+		}
+		return 1, nil
+	}
+	if Number_Q(x) && Number_Q(y) {
+		c, _ := num_compare(x, y)
+		return c, nil
+	}
+	switch tx := x.(type) {
+	case string:
+		if ty, ok := y.(string); ok && Keyword_Q(tx) == Keyword_Q(ty) {
+			return strings.Compare(tx, ty), nil
+		}
+	case Symbol:
+		if ty, ok := y.(Symbol); ok {
+			return strings.Compare(tx.Val, ty.Val), nil
+		}
+	case bool:
+		if ty, ok := y.(bool); ok {
+			switch {
+			case tx == ty:
+				return 0, nil
+			case !tx:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case Instant:
+		if ty, ok := y.(Instant); ok {
+			return tx.T.Compare(ty.T), nil
+		}
+	case List, Vector:
+		if Sequential_Q(y) {
+			xs, _ := GetSlice(x)
+			ys, _ := GetSlice(y)
+			for i := 0; i < len(xs) && i < len(ys); i += 1 {
+				if c, e := compare_values(xs[i], ys[i]); e != nil || c != 0 {
+					return c, e
+				}
+			}
+			return compare_values(len(xs), len(ys))
+		}
+	}
+	return 0, NewTypeError("cannot compare %s with %s", TypeName(x), TypeName(y))
+}
+
+// comparator returns a function ordering values with cmp, a function
+// returning a number (negative, zero or positive) or a boolean (true
+// if its first argument comes first), or with compare_values if cmp is
+// nil
+func comparator(ec *EvalContext, cmp MalType) func(x, y MalType) (int, error) {
+	if cmp == nil {
+		return compare_values
+	}
+	return func(x, y MalType) (int, error) {
+		res, e := Apply(ec, cmp, []MalType{x, y})
+		if e != nil {
+			return 0, e
+		}
+		switch r := res.(type) {
+		case int:
+			return r, nil
+		case float64:
+			c, _ := num_compare(r, 0)
+			return c, nil
+		case bool:
+			if r {
+				return -1, nil
+			}
+			// tell equal elements from those after y apart
+			res, e := Apply(ec, cmp, []MalType{y, x})
+			if e != nil {
+				return 0, e
+			}
+			if truthy(res) {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return 0, NewTypeError("comparator returned %s instead of number or boolean", TypeName(res))
+	}
+}
+
+// sort_keyed stably sorts slc by keys with cmp
+func sort_keyed(slc []MalType, keys []MalType, cmp func(x, y MalType) (int, error)) (MalType, error) {
+	idx := make([]int, len(slc))
+	for i := range idx {
+		idx[i] = i
+	}
+	var err error
+	sort.SliceStable(idx, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		c, e := cmp(keys[idx[i]], keys[idx[j]])
+		if e != nil {
+			err = e
+		}
+		return c < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MalType, len(slc))
+	for i, k := range idx {
+		res[i] = slc[k]
+	}
+	return List{res, nil}, nil
+}
+
+// (sort coll) and (sort cmp coll)
+func do_sort(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	slc, e := arg_seq("sort", a[len(a)-1])
+	if e != nil {
+		return nil, e
+	}
+	var cmp MalType
+	if len(a) == 2 {
+		cmp = a[0]
+	}
+	return sort_keyed(slc, slc, comparator(ec, cmp))
+}
+
+// (sort-by keyfn coll) and (sort-by keyfn cmp coll)
+func sort_by(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	slc, e := arg_seq("sort-by", a[len(a)-1])
+	if e != nil {
+		return nil, e
+	}
+	keys := make([]MalType, len(slc))
+	for i, x := range slc {
+		if keys[i], e = Apply(ec, a[0], []MalType{x}); e != nil {
+			return nil, e
+		}
+	}
+	var cmp MalType
+	if len(a) == 3 {
+		cmp = a[1]
+	}
+	return sort_keyed(slc, keys, comparator(ec, cmp))
+}
+
+// group_by returns a hash-map from (f x) to the vector of the elements x
+// giving it. Hash-map keys are strings, so f must return a string or a
+// keyword; group by (str (f x)) to use other values.
+func group_by(ec *EvalContext, a []MalType) (MalType, error) {
+	slc, e := arg_seq("group-by", a[1])
+	if e != nil {
+		return nil, e
+	}
+	groups := map[string][]MalType{}
+	for _, x := range slc {
+		v, e := Apply(ec, a[0], []MalType{x})
+		if e != nil {
+			return nil, e
+		}
+		k, e := arg_key("group-by", v)
+		if e != nil {
+			return nil, e
+		}
+		groups[k] = append(groups[k], x)
+	}
+	m := map[string]MalType{}
+	for k, g := range groups {
+		m[k] = Vector{g, nil}
+	}
+	return HashMap{m, nil}, nil
+}
+
+// frequencies returns a hash-map from each element to the number of
+// times it occurs. As for group_by, the elements must be strings or
+// keywords; count (map str coll) for other values.
+func frequencies(a []MalType) (MalType, error) {
+	slc, e := arg_seq("frequencies", a[0])
+	if e != nil {
+		return nil, e
+	}
+	m := map[string]MalType{}
+	for _, x := range slc {
+		k, e := arg_key("frequencies", x)
+		if e != nil {
+			return nil, e
+		}
+		n, _ := m[k].(int)
+		m[k] = n + 1
+	}
+	return HashMap{m, nil}, nil
+}
+
+// distinct keeps the first of equal elements
+func distinct(a []MalType) (MalType, error) {
+	slc, e := arg_seq("distinct", a[0])
+	if e != nil {
+		return nil, e
+	}
+	seen := map[uint64][]MalType{}
+	res := []MalType{}
+	for _, x := range slc {
+		h := hash_value(x)
+		dup := false
+		for _, y := range seen[h] {
+			if Equal_Q(x, y) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			seen[h] = append(seen[h], x)
+			res = append(res, x)
+		}
+	}
+	return List{res, nil}, nil
+}
+
+// (range end), (range start end) and (range start end step)
+func do_range(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) < 1 || len(a) > 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 to 3)", len(a))
+	}
+	for _, x := range a {
+		if _, e := arg_num("range", x); e != nil {
+			return nil, e
+		}
+	}
+	var start, end, step MalType = 0, a[0], 1
+	if len(a) > 1 {
+		start, end = a[0], a[1]
+	}
+	if len(a) == 3 {
+		step = a[2]
+	}
+	dir, _ := num_compare(step, 0)
+	if dir == 0 {
+		return nil, NewTypeError("range: step must not be zero")
+	}
+	if n, ok := range_size(start, end, step); ok {
+		if e := check_alloc(ec, n); e != nil {
+			return nil, e
+		}
+	}
+	res := []MalType{}
+	for x := start; ; {
+		if c, _ := num_compare(x, end); c*dir >= 0 {
+			break
+		}
+		res = append(res, x)
+		x, _ = add.apply(x, step)
+	}
+	return List{res, nil}, nil
+}
+
+// range_size estimates the number of elements of a range
+func range_size(start, end, step MalType) (int, bool) {
+	n := (to_float(end) - to_float(start)) / to_float(step)
+	if n <= 0 {
+		return 0, true
+	}
+	if n > 1<<40 {
+		return 1 << 40, true
+	}
+	return int(n) + 1, true
+}
+
+// into adds the elements of from to to as conj does, or as entries
+// when to is a hash-map
+func into(a []MalType) (MalType, error) {
+	from, e := arg_seq("into", a[1])
+	if e != nil {
+		return nil, e
+	}
+	switch to := a[0].(type) {
+	case nil:
+		return conj(append([]MalType{List{}}, from...))
+	case List, Vector:
+		if len(from) == 0 {
+			return to, nil
+		}
+		return conj(append([]MalType{to}, from...))
+	case HashMap:
+		m := copy_hash_map(to)
+		for _, x := range from {
+			kv, e := GetSlice(x)
+			if e != nil || len(kv) != 2 {
+				return nil, NewTypeError("into: hash-map entries must be [key value] pairs")
+			}
+			k, e := arg_key("into", kv[0])
+			if e != nil {
+				return nil, e
+			}
+			m.Val[k] = kv[1]
+		}
+		return m, nil
+	}
+	return nil, NewTypeError("into: expected collection, got %s", TypeName(a[0]))
+}
+
+func zipmap(a []MalType) (MalType, error) {
+	keys, e := arg_seq("zipmap", a[0])
+	if e != nil {
+		return nil, e
+	}
+	vals, e := arg_seq("zipmap", a[1])
+	if e != nil {
+		return nil, e
+	}
+	m := map[string]MalType{}
+	for i := 0; i < len(keys) && i < len(vals); i += 1 {
+		k, e := arg_key("zipmap", keys[i])
+		if e != nil {
+			return nil, e
+		}
+		m[k] = vals[i]
+	}
+	return HashMap{m, nil}, nil
+}
+
+// seq_args returns the collections in a, and the length of the
+// shortest
+func seq_args(name string, a []MalType) ([][]MalType, int, error) {
+	colls := make([][]MalType, len(a))
+	shortest := -1
+	for i, x := range a {
+		slc, e := arg_seq(name, x)
+		if e != nil {
+			return nil, 0, e
+		}
+		colls[i] = slc
+		if shortest < 0 || len(slc) < shortest {
+			shortest = len(slc)
+		}
+	}
+	if shortest < 0 {
+		shortest = 0
+	}
+	return colls, shortest, nil
+}
+
+func interleave(a []MalType) (MalType, error) {
+	colls, n, e := seq_args("interleave", a)
+	if e != nil {
+		return nil, e
+	}
+	res := make([]MalType, 0, n*len(colls))
+	for i := 0; i < n; i += 1 {
+		for _, c := range colls {
+			res = append(res, c[i])
+		}
+	}
+	return List{res, nil}, nil
+}
+
+// (map f coll...) calls f with an element of each collection, up to
+// the end of the shortest
+func do_map(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) < 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of at least 2)", len(a))
+	}
+	colls, n, e := seq_args("map", a[1:])
+	if e != nil {
+		return nil, e
+	}
+	if e := check_alloc(ec, n); e != nil {
+		return nil, e
+	}
+	results := make([]MalType, n)
+	for i := 0; i < n; i += 1 {
+		args := make([]MalType, len(colls))
+		for j, c := range colls {
+			args[j] = c[i]
+		}
+		if results[i], e = Apply(ec, a[0], args); e != nil {
+			return nil, e
+		}
+	}
+	return List{results, nil}, nil
+}
+
+func mapcat(ec *EvalContext, a []MalType) (MalType, error) {
+	mapped, e := do_map(ec, a)
+	if e != nil {
+		return nil, e
+	}
+	return concat(ec, mapped.(List).Val)
+}
+
+func last(a []MalType) (MalType, error) {
+	slc, e := arg_seq("last", a[0])
+	if e != nil || len(slc) == 0 {
+		return nil, e
+	}
+	return slc[len(slc)-1], nil
+}
+
+func butlast(a []MalType) (MalType, error) {
+	slc, e := arg_seq("butlast", a[0])
+	if e != nil || len(slc) <= 1 {
+		return nil, e
+	}
+	return List{slc[: len(slc)-1 : len(slc)-1], nil}, nil
+}
+
+func reverse(a []MalType) (MalType, error) {
+	slc, e := arg_seq("reverse", a[0])
+	if e != nil {
+		return nil, e
+	}
+	res := make([]MalType, len(slc))
+	for i, x := range slc {
+		res[len(slc)-1-i] = x
+	}
+	return List{res, nil}, nil
+}
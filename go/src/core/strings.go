@@ -0,0 +1,257 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// String functions. Indices count characters (runes), not bytes.
+
+// rune_index converts a byte offset in s to a character index
+func rune_index(s string, i int) int {
+	return utf8.RuneCountInString(s[:i])
+}
+
+// byte_offset converts a character index in s to a byte offset, failing
+// if the index is beyond the end of s
+func byte_offset(name string, s string, idx int) (int, error) {
+	if idx < 0 {
+		return 0, errors.New(name + ": index out of range")
+	}
+	n := 0
+	for i := range s {
+		if n == idx {
+			return i, nil
+		}
+		n += 1
+	}
+	if n == idx {
+		return len(s), nil
+	}
+	return 0, errors.New(name + ": index out of range")
+}
+
+func string_args(name string, a []MalType) ([]string, error) {
+	strs := make([]string, len(a))
+	for i, x := range a {
+		var e error
+		if strs[i], e = arg_string(name, x); e != nil {
+			return nil, e
+		}
+	}
+	return strs, nil
+}
+
+func subs(a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	s, e := arg_string("subs", a[0])
+	if e != nil {
+		return nil, e
+	}
+	idx := make([]int, len(a)-1)
+	for i, x := range a[1:] {
+		n, e := arg_number("subs", x)
+		if e != nil {
+			return nil, e
+		}
+		if idx[i], e = byte_offset("subs", s, n); e != nil {
+			return nil, e
+		}
+	}
+	if len(idx) == 1 {
+		return s[idx[0]:], nil
+	}
+	if idx[1] < idx[0] {
+		return nil, errors.New("subs: index out of range")
+	}
+	return s[idx[0]:idx[1]], nil
+}
+
+func strings_to_vector(strs []string) MalType {
+	slc := make([]MalType, len(strs))
+	for i, s := range strs {
+		slc[i] = s
+	}
+	return Vector{slc, nil}
+}
+
+// (split s sep) and (split s sep limit) return at most limit parts, or
+// all of them when limit is not positive. sep is a string or a regex.
+func split(a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	s, e := arg_string("split", a[0])
+	if e != nil {
+		return nil, e
+	}
+	limit := -1
+	if len(a) == 3 {
+		if limit, e = arg_number("split", a[2]); e != nil {
+			return nil, e
+		}
+		if limit <= 0 {
+			limit = -1
+		}
+	}
+	if re, ok := a[1].(Regex); ok {
+		return strings_to_vector(re.Re.Split(s, limit)), nil
+	}
+	sep, e := arg_string("split", a[1])
+	if e != nil {
+		return nil, e
+	}
+	return strings_to_vector(strings.SplitN(s, sep, limit)), nil
+}
+
+func split_lines(a []MalType) (MalType, error) {
+	s, e := arg_string("split-lines", a[0])
+	if e != nil {
+		return nil, e
+	}
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings_to_vector(lines), nil
+}
+
+// (join coll) and (join sep coll) join the elements of coll as by str
+func join(a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	sep := ""
+	if len(a) == 2 {
+		var e error
+		if sep, e = arg_string("join", a[0]); e != nil {
+			return nil, e
+		}
+	}
+	coll := a[len(a)-1]
+	if coll == nil {
+		return "", nil
+	}
+	slc, e := GetSlice(coll)
+	if e != nil {
+		return nil, NewTypeError("join: expected sequence, got %s", TypeName(coll))
+	}
+	return printer.Pr_list(slc, false, "", "", sep), nil
+}
+
+// string_fn lifts a function on a string into a core function
+func string_fn(name string, f func(string) MalType) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		s, e := arg_string(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		return f(s), nil
+	}
+}
+
+// string_pred lifts a predicate on two strings into a core function
+func string_pred(name string, f func(string, string) bool) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		strs, e := string_args(name, a)
+		if e != nil {
+			return nil, e
+		}
+		return f(strs[0], strs[1]), nil
+	}
+}
+
+func upper_case(s string) MalType {
+	return strings.ToUpper(s)
+}
+
+func lower_case(s string) MalType {
+	return strings.ToLower(s)
+}
+
+func trim(s string) MalType {
+	return strings.TrimSpace(s)
+}
+
+func triml(s string) MalType {
+	return strings.TrimLeftFunc(s, unicode.IsSpace)
+}
+
+func trimr(s string) MalType {
+	return strings.TrimRightFunc(s, unicode.IsSpace)
+}
+
+func capitalize(s string) MalType {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + strings.ToLower(s[n:])
+}
+
+func blank_Q(a []MalType) (MalType, error) {
+	if a[0] == nil {
+		return true, nil
+	}
+	s, e := arg_string("blank?", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return strings.TrimSpace(s) == "", nil
+}
+
+// (index-of s value) and (index-of s value from) return the index of
+// the first occurrence of value at or after from, or nil
+func index_of(a []MalType) (MalType, error) {
+	if len(a) != 2 && len(a) != 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2 or 3)", len(a))
+	}
+	strs, e := string_args("index-of", a[:2])
+	if e != nil {
+		return nil, e
+	}
+	start := 0
+	if len(a) == 3 {
+		from, e := arg_number("index-of", a[2])
+		if e != nil {
+			return nil, e
+		}
+		if start, e = byte_offset("index-of", strs[0], from); e != nil {
+			return nil, e
+		}
+	}
+	i := strings.Index(strs[0][start:], strs[1])
+	if i < 0 {
+		return nil, nil
+	}
+	return rune_index(strs[0], start+i), nil
+}
+
+// (replace s match replacement) replaces every occurrence of match, a
+// string or a regex (see regex_replace)
+func replace(ec *EvalContext, a []MalType) (MalType, error) {
+	s, e := arg_string("replace", a[0])
+	if e != nil {
+		return nil, e
+	}
+	if re, ok := a[1].(Regex); ok {
+		return regex_replace(ec, re, s, a[2])
+	}
+	strs, e := string_args("replace", a[1:])
+	if e != nil {
+		return nil, e
+	}
+	return strings.ReplaceAll(s, strs[0], strs[1]), nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"testing"
+)
+
+import (
+	. "types"
+)
+
+// The string functions index by character, which runtest.py cannot
+// check since it does not decode multi-byte output
+
+func TestStringsMultibyte(t *testing.T) {
+	tests := []struct {
+		fn   string
+		args []MalType
+		want MalType
+	}{
+		{"subs", []MalType{"héllo wörld", 1, 4}, "éll"},
+		{"subs", []MalType{"héllo", 2}, "llo"},
+		{"subs", []MalType{"日本語", 1, 3}, "本語"},
+		{"upper-case", []MalType{"straße"}, "STRAßE"},
+		{"lower-case", []MalType{"ÀÉ"}, "àé"},
+		{"capitalize", []MalType{"éCOLE"}, "École"},
+		{"starts-with?", []MalType{"héllo", "hé"}, true},
+		{"includes?", []MalType{"héllo", "él"}, true},
+		{"index-of", []MalType{"héllo", "l"}, 2},
+		{"index-of", []MalType{"héllo", "l", 3}, 3},
+		{"index-of", []MalType{"日本語", "語"}, 2},
+		{"index-of", []MalType{"héllo", "z"}, nil},
+		{"split", []MalType{"a→b→c", "→"}, Vector{[]MalType{"a", "b", "c"}, nil}},
+	}
+	for _, tt := range tests {
+		got, e := NS[tt.fn].(func(*EvalContext, []MalType) (MalType, error))(nil, tt.args)
+		if e != nil {
+			t.Errorf("%s %v: %v", tt.fn, tt.args, e)
+		} else if !Equal_Q(got, tt.want) {
+			t.Errorf("%s %v = %#v, want %#v", tt.fn, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSubsOutOfRangeMultibyte(t *testing.T) {
+	if _, e := NS["subs"].(func(*EvalContext, []MalType) (MalType, error))(nil, []MalType{"héllo", 6}); e == nil {
+		t.Errorf("subs past the last character: expected an error")
+	}
+}
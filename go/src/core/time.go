@@ -0,0 +1,268 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+import (
+	"printer"
+	. "types"
+)
+
+// Dates and times. Instants are points in time in a time zone;
+// durations are integer milliseconds, like time-ms and sleep.
+
+func arg_instant(name string, a MalType) (time.Time, error) {
+	inst, ok := a.(Instant)
+	if !ok {
+		return time.Time{}, NewTypeError("%s: expected instant, got %s", name, TypeName(a))
+	}
+	return inst.T, nil
+}
+
+func now(a []MalType) (MalType, error) {
+	return Instant{time.Now()}, nil
+}
+
+// start is the origin of nano-time
+var start = time.Now()
+
+// nano_time returns monotonic nanoseconds, for measuring intervals
+func nano_time(a []MalType) (MalType, error) {
+	return int(time.Since(start).Nanoseconds()), nil
+}
+
+// inst_ms returns the milliseconds since the Unix epoch
+func inst_ms(a []MalType) (MalType, error) {
+	t, e := arg_instant("inst-ms", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return int(t.UnixNano() / int64(time.Millisecond)), nil
+}
+
+func inst_from_ms(a []MalType) (MalType, error) {
+	ms, e := arg_number("inst-from-ms", a[0])
+	if e != nil {
+		return nil, e
+	}
+	return Instant{time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC()}, nil
+}
+
+// Layouts are Go reference time layouts, or one of these names
+var layouts = map[string]string{
+	"\u029erfc3339":      time.RFC3339,
+	"\u029erfc3339-nano": time.RFC3339Nano,
+	"\u029erfc1123":      time.RFC1123,
+	"\u029erfc1123z":     time.RFC1123Z,
+	"\u029edate-time":    time.DateTime,
+	"\u029edate":         time.DateOnly,
+	"\u029etime":         time.TimeOnly,
+	"\u029ekitchen":      time.Kitchen,
+}
+
+func arg_layout(name string, a MalType) (string, error) {
+	if Keyword_Q(a) {
+		if layout, ok := layouts[a.(string)]; ok {
+			return layout, nil
+		}
+		return "", fmt.Errorf("%s: unknown layout %s", name, printer.Pr_str(a, true))
+	}
+	return arg_string(name, a)
+}
+
+func arg_zone(name string, a MalType) (*time.Location, error) {
+	zone, e := arg_string(name, a)
+	if e != nil {
+		return nil, e
+	}
+	loc, e := time.LoadLocation(zone)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %v", name, e)
+	}
+	return loc, nil
+}
+
+// (parse-time str) reads an RFC 3339 timestamp, (parse-time layout str)
+// one in the given layout and (parse-time layout str zone) one without
+// an offset in the given time zone, UTC by default
+func parse_time(a []MalType) (MalType, error) {
+	if len(a) < 1 || len(a) > 3 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 to 3)", len(a))
+	}
+	layout := time.RFC3339Nano
+	if len(a) > 1 {
+		var e error
+		if layout, e = arg_layout("parse-time", a[0]); e != nil {
+			return nil, e
+		}
+	}
+	str, e := arg_string("parse-time", a[len(a)-1])
+	if len(a) == 3 {
+		str, e = arg_string("parse-time", a[1])
+	}
+	if e != nil {
+		return nil, e
+	}
+	loc := time.UTC
+	if len(a) == 3 {
+		if loc, e = arg_zone("parse-time", a[2]); e != nil {
+			return nil, e
+		}
+	}
+	t, e := time.ParseInLocation(layout, str, loc)
+	if e != nil {
+		return nil, fmt.Errorf("parse-time: %v", e)
+	}
+	return Instant{t}, nil
+}
+
+// (format-time inst) writes an RFC 3339 timestamp, (format-time inst
+// layout) uses the given layout
+func format_time(a []MalType) (MalType, error) {
+	if len(a) != 1 && len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	t, e := arg_instant("format-time", a[0])
+	if e != nil {
+		return nil, e
+	}
+	layout := time.RFC3339Nano
+	if len(a) == 2 {
+		if layout, e = arg_layout("format-time", a[1]); e != nil {
+			return nil, e
+		}
+	}
+	return t.Format(layout), nil
+}
+
+// in_zone returns the same instant in another time zone, by name as
+// in "UTC", "Local" or "Europe/Paris"
+func in_zone(a []MalType) (MalType, error) {
+	t, e := arg_instant("in-zone", a[0])
+	if e != nil {
+		return nil, e
+	}
+	loc, e := arg_zone("in-zone", a[1])
+	if e != nil {
+		return nil, e
+	}
+	return Instant{t.In(loc)}, nil
+}
+
+// time_fields returns the calendar fields of an instant in its zone
+func time_fields(a []MalType) (MalType, error) {
+	t, e := arg_instant("time-fields", a[0])
+	if e != nil {
+		return nil, e
+	}
+	zone, offset := t.Zone()
+	return HashMap{map[string]MalType{
+		"\u029eyear":    t.Year(),
+		"\u029emonth":   int(t.Month()),
+		"\u029eday":     t.Day(),
+		"\u029ehour":    t.Hour(),
+		"\u029eminute":  t.Minute(),
+		"\u029esecond":  t.Second(),
+		"\u029enano":    t.Nanosecond(),
+		"\u029eweekday": t.Weekday().String(),
+		"\u029ezone":    zone,
+		"\u029eoffset":  offset,
+	}, nil}, nil
+}
+
+var duration_units = map[string]time.Duration{
+	"\u029ems":      time.Millisecond,
+	"\u029eseconds": time.Second,
+	"\u029eminutes": time.Minute,
+	"\u029ehours":   time.Hour,
+	"\u029edays":    24 * time.Hour,
+	"\u029eweeks":   7 * 24 * time.Hour,
+}
+
+// (duration "1h30m") parses a Go duration and (duration n unit)
+// converts n :seconds, :minutes, etc., to milliseconds
+func duration(a []MalType) (MalType, error) {
+	var d time.Duration
+	switch len(a) {
+	case 1:
+		str, e := arg_string("duration", a[0])
+		if e != nil {
+			return nil, e
+		}
+		if d, e = time.ParseDuration(str); e != nil {
+			return nil, fmt.Errorf("duration: %v", e)
+		}
+	case 2:
+		if _, e := arg_num("duration", a[0]); e != nil {
+			return nil, e
+		}
+		unit, ok := a[1].(string)
+		if _, known := duration_units[unit]; !ok || !known {
+			return nil, NewTypeError("duration: expected a unit such as :seconds, got %s", TypeName(a[1]))
+		}
+		d = time.Duration(math.Round(to_float(a[0]) * float64(duration_units[unit])))
+	default:
+		return nil, NewArityError("wrong number of arguments (%d instead of 1 or 2)", len(a))
+	}
+	return int(d / time.Millisecond), nil
+}
+
+func time_add(a []MalType) (MalType, error) {
+	t, e := arg_instant("time-add", a[0])
+	if e != nil {
+		return nil, e
+	}
+	ms, e := arg_number("time-add", a[1])
+	if e != nil {
+		return nil, e
+	}
+	return Instant{t.Add(time.Duration(ms) * time.Millisecond)}, nil
+}
+
+// (time-diff a b) returns the milliseconds from b to a
+func time_diff(a []MalType) (MalType, error) {
+	t1, e := arg_instant("time-diff", a[0])
+	if e != nil {
+		return nil, e
+	}
+	t2, e := arg_instant("time-diff", a[1])
+	if e != nil {
+		return nil, e
+	}
+	return int(t1.Sub(t2) / time.Millisecond), nil
+}
+
+func time_order(name string, before bool) func([]MalType) (MalType, error) {
+	return func(a []MalType) (MalType, error) {
+		t1, e := arg_instant(name, a[0])
+		if e != nil {
+			return nil, e
+		}
+		t2, e := arg_instant(name, a[1])
+		if e != nil {
+			return nil, e
+		}
+		if before {
+			return t1.Before(t2), nil
+		}
+		return t1.After(t2), nil
+	}
+}
+
+// sleep waits for ms milliseconds unless the evaluation is interrupted
+func sleep(ec *EvalContext, a []MalType) (MalType, error) {
+	ms, e := arg_number("sleep", a[0])
+	if e != nil {
+		return nil, e
+	}
+	ctx := eval_context(ec)
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ec.Check()
+	}
+}
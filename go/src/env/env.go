@@ -2,6 +2,8 @@ package env
 
 import (
 	"errors"
+	"reflect"
+	"strings"
 	//"fmt"
 )
 
@@ -18,27 +20,182 @@ func NewEnv(outer EnvType, binds_mt MalType, exprs_mt MalType) (EnvType, error)
 	env := Env{map[string]MalType{}, outer}
 
 	if binds_mt != nil && exprs_mt != nil {
-		binds, e := GetSlice(binds_mt)
-		if e != nil {
+		// Return a new Env with the patterns in binds bound to
+		// corresponding values in exprs
+		if e := Bind(nil, env, binds_mt, exprs_mt, nil); e != nil {
 			return nil, e
 		}
-		exprs, e := GetSlice(exprs_mt)
+	}
+	//return &et, nil
+	return env, nil
+}
+
+// Bind destructures value according to pattern and sets the symbols
+// it names in env. A pattern is a symbol, a sequential pattern such as
+// [a b & more :as all] or an associative pattern such as
+// {:keys [x y] :strs [z] :or [y 1] :as m}, nested arbitrarily. Since
+// hash-map keys are strings, :or defaults are given as symbol default
+// pairs in a sequence, or as a hash-map keyed by the keyword (or
+// string) naming the local, as in :or {:y 1}. Defaults are evaluated in
+// env with eval, as part of the evaluation ec, when eval is non-nil and
+// used as-is otherwise. The :keys locals are bound before the :strs
+// ones, and :as last.
+func Bind(ec *EvalContext, env EnvType, pattern MalType, value MalType,
+	eval func(*EvalContext, MalType, EnvType) (MalType, error)) error {
+	switch p := pattern.(type) {
+	case Symbol:
+		env.Set(p, value)
+		return nil
+	case List:
+		return bind_seq(ec, env, p.Val, value, eval)
+	case Vector:
+		return bind_seq(ec, env, p.Val, value, eval)
+	case HashMap:
+		return bind_map(ec, env, p.Val, value, eval)
+	default:
+		return errors.New("non-symbol bind value")
+	}
+}
+
+func bind_seq(ec *EvalContext, env EnvType, binds []MalType, value MalType,
+	eval func(*EvalContext, MalType, EnvType) (MalType, error)) error {
+	var exprs []MalType
+	if value != nil {
+		var e error
+		if exprs, e = GetSlice(value); e != nil {
+			return errors.New("cannot destructure non-sequence with sequential pattern")
+		}
+	}
+	idx := 0
+	for i := 0; i < len(binds); i += 1 {
+		if Symbol_Q(binds[i]) && binds[i].(Symbol).Val == "&" {
+			if i+1 >= len(binds) {
+				return errors.New("missing pattern after '&'")
+			}
+			more := []MalType{}
+			if idx < len(exprs) {
+				more = exprs[idx:]
+			}
+			idx = len(exprs)
+			if e := Bind(ec, env, binds[i+1], List{more, nil}, eval); e != nil {
+				return e
+			}
+			i += 1
+		} else if Keyword_Q(binds[i]) && binds[i].(string) == "\u029eas" {
+			if i+1 >= len(binds) {
+				return errors.New("missing pattern after ':as'")
+			}
+			if e := Bind(ec, env, binds[i+1], value, eval); e != nil {
+				return e
+			}
+			i += 1
+		} else {
+			var exp MalType = nil
+			if idx < len(exprs) {
+				exp = exprs[idx]
+			}
+			idx += 1
+			if e := Bind(ec, env, binds[i], exp, eval); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func bind_map(ec *EvalContext, env EnvType, binds map[string]MalType, value MalType,
+	eval func(*EvalContext, MalType, EnvType) (MalType, error)) error {
+	hm := map[string]MalType{}
+	switch v := value.(type) {
+	case nil:
+	case HashMap:
+		hm = v.Val
+	case List, Vector:
+		// keyword arguments from a rest pattern: [& {:keys [k]}]
+		slc, _ := GetSlice(v)
+		if len(slc) == 1 && HashMap_Q(slc[0]) {
+			hm = slc[0].(HashMap).Val
+			break
+		}
+		kw, e := NewHashMap(v)
 		if e != nil {
-			return nil, e
+			return e
 		}
-		// Return a new Env with symbols in binds boudn to
-		// corresponding values in exprs
-		for i := 0; i < len(binds); i += 1 {
-			if Symbol_Q(binds[i]) && binds[i].(Symbol).Val == "&" {
-				env.data[binds[i+1].(Symbol).Val] = List{exprs[i:], nil}
-				break
-			} else {
-				env.data[binds[i].(Symbol).Val] = exprs[i]
+		hm = kw.(HashMap).Val
+	default:
+		return errors.New("cannot destructure non-hash-map with associative pattern")
+	}
+	defaults := map[string]MalType{}
+	if or, ok := binds["\u029eor"]; ok {
+		if e := or_defaults(defaults, or); e != nil {
+			return e
+		}
+	}
+	for k := range binds {
+		switch k {
+		case "\u029eor", "\u029eas", "\u029ekeys", "\u029estrs":
+		default:
+			return errors.New("unsupported key '" + strings.TrimPrefix(k, "\u029e") + "' in associative pattern")
+		}
+	}
+	// bound in a fixed order so that :or defaults can refer to the
+	// locals of an earlier group
+	for _, group := range []struct{ key, prefix string }{
+		{"\u029ekeys", "\u029e"},
+		{"\u029estrs", ""},
+	} {
+		v, ok := binds[group.key]
+		if !ok {
+			continue
+		}
+		syms, e := GetSlice(v)
+		if e != nil {
+			return errors.New(":keys and :strs require a sequence of symbols")
+		}
+		for _, sym := range syms {
+			if !Symbol_Q(sym) {
+				return errors.New(":keys and :strs require a sequence of symbols")
 			}
+			name := sym.(Symbol).Val
+			exp, found := hm[group.prefix+name]
+			if !found {
+				if exp, found = defaults[name]; found && eval != nil {
+					if exp, e = eval(ec, exp, env); e != nil {
+						return e
+					}
+				}
+			}
+			env.Set(sym.(Symbol), exp)
 		}
 	}
-	//return &et, nil
-	return env, nil
+	if as, ok := binds["\u029eas"]; ok {
+		return Bind(ec, env, as, value, eval)
+	}
+	return nil
+}
+
+// or_defaults reads the defaults of an :or, either symbol default pairs
+// in a sequence or a hash-map keyed by keywords or strings, into
+// defaults by local name
+func or_defaults(defaults map[string]MalType, or MalType) error {
+	if hm, ok := or.(HashMap); ok {
+		for k, v := range hm.Val {
+			defaults[strings.TrimPrefix(k, "\u029e")] = v
+		}
+		return nil
+	}
+	pairs, e := GetSlice(or)
+	if e != nil || len(pairs)%2 != 0 {
+		return errors.New(":or requires symbol default pairs or a hash-map of defaults")
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		sym, ok := pairs[i].(Symbol)
+		if !ok {
+			return errors.New(":or requires symbol default pairs or a hash-map of defaults")
+		}
+		defaults[sym.Val] = pairs[i+1]
+	}
+	return nil
 }
 
 func (e Env) Find(key Symbol) EnvType {
@@ -56,6 +213,9 @@ func (e Env) Set(key Symbol, value MalType) MalType {
 	return value
 }
 
+// Get returns the value of key. That of a dynamic var is the *Var,
+// whose value depends on the bindings of the evaluation (see
+// EvalContext.Deref).
 func (e Env) Get(key Symbol) (MalType, error) {
 	env := e.Find(key)
 	if env == nil {
@@ -63,3 +223,40 @@ func (e Env) Get(key Symbol) (MalType, error) {
 	}
 	return env.(Env).data[key.Val], nil
 }
+
+// Same reports whether other is e. Env is a value type, so two Envs
+// are the same when they share their bindings.
+func (e Env) Same(other EnvType) bool {
+	o, ok := other.(Env)
+	return ok && reflect.ValueOf(e.data).Pointer() == reflect.ValueOf(o.data).Pointer()
+}
+
+// Locals returns the bindings visible in e, excluding those of the
+// outermost (global) environment, with dynamic vars as bound in ec
+func Locals(ec *EvalContext, e EnvType) map[string]MalType {
+	locals := map[string]MalType{}
+	for env, ok := e.(Env); ok && env.outer != nil; env, ok = env.outer.(Env) {
+		for k, v := range env.data {
+			if _, shadowed := locals[k]; !shadowed {
+				if dv, ok := v.(*Var); ok {
+					v = ec.Deref(dv)
+				}
+				locals[k] = v
+			}
+		}
+	}
+	return locals
+}
+
+// GetVar returns the dynamic var that key resolves to in e
+func GetVar(e EnvType, key Symbol) (*Var, error) {
+	env := e.Find(key)
+	if env == nil {
+		return nil, errors.New("'" + key.Val + "' not found")
+	}
+	v, ok := env.(Env).data[key.Val].(*Var)
+	if !ok {
+		return nil, errors.New("can't dynamically bind non-dynamic var '" + key.Val + "'")
+	}
+	return v, nil
+}
@@ -1,8 +1,11 @@
 package printer
 
 import (
+	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 import (
@@ -43,6 +46,13 @@ func Pr_str(obj types.MalType, print_readably bool) string {
 		} else {
 			return tobj
 		}
+	case float64:
+		s := strconv.FormatFloat(tobj, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			// keep floats with integral values distinct from ints
+			s += ".0"
+		}
+		return s
 	case types.Symbol:
 		return tobj.Val
 	case nil:
@@ -56,6 +66,27 @@ func Pr_str(obj types.MalType, print_readably bool) string {
 	case *types.Atom:
 		return "(atom " +
 			Pr_str(tobj.Val, true) + ")"
+	case *types.Var:
+		return "#'" + tobj.Sym.Val
+	case *types.Handle:
+		if buf, ok := tobj.Writer.(*bytes.Buffer); ok && !print_readably {
+			return buf.String()
+		}
+		return "#<handle " + tobj.Name + ">"
+	case *types.Process:
+		return fmt.Sprintf("#<process %s %d>", tobj.Cmd.Args[0], tobj.Cmd.Process.Pid)
+	case types.Regex:
+		return `#"` + strings.Replace(tobj.Re.String(), `"`, `\"`, -1) + `"`
+	case types.Instant:
+		if print_readably {
+			return `#inst "` + tobj.T.Format(time.RFC3339Nano) + `"`
+		}
+		return tobj.T.Format(time.RFC3339Nano)
+	case *types.Delay:
+		if !tobj.Realized() {
+			return "#<delay pending>"
+		}
+		return "#<delay " + Pr_str(tobj.Value(), true) + ">"
 	default:
 		return fmt.Sprintf("%v", obj)
 	}
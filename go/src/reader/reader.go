@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	//"fmt"
 )
 
@@ -15,11 +16,15 @@ import (
 type Reader interface {
 	next() *string
 	peek() *string
+	loc() MalType
 }
 
 type TokenReader struct {
 	tokens   []string
+	lines    []int
+	columns  []int
 	position int
+	file     string
 }
 
 func (tr *TokenReader) next() *string {
@@ -38,26 +43,65 @@ func (tr *TokenReader) peek() *string {
 	return &tr.tokens[tr.position]
 }
 
-func tokenize(str string) []string {
+// loc returns source position metadata for the token about to be read
+func (tr *TokenReader) loc() MalType {
+	if tr.position >= len(tr.lines) {
+		return nil
+	}
+	l, _ := NewKeyword("line")
+	c, _ := NewKeyword("column")
+	m := map[string]MalType{
+		l.(string): tr.lines[tr.position],
+		c.(string): tr.columns[tr.position],
+	}
+	if tr.file != "" {
+		f, _ := NewKeyword("file")
+		m[f.(string)] = tr.file
+	}
+	return HashMap{m, nil}
+}
+
+// tokenize splits str into tokens and returns the line and column at
+// which each token starts
+func tokenize(str string) ([]string, []int, []int) {
 	results := make([]string, 0, 1)
+	lines := make([]int, 0, 1)
+	columns := make([]int, 0, 1)
+	line, line_start, scanned := 1, 0, 0
 	// Work around lack of quoting in backtick
 	re := regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" +
-		`~^@]|"(?:\\.|[^\\"])*"|;.*|[^\s\[\]{}('"` + "`" +
+		`~^@]|#?"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" +
 		`,;)]*)`)
-	for _, group := range re.FindAllStringSubmatch(str, -1) {
-		if (group[1] == "") || (group[1][0] == ';') {
+	for _, group := range re.FindAllStringSubmatchIndex(str, -1) {
+		token := str[group[2]:group[3]]
+		if (token == "") || (token[0] == ';') {
 			continue
 		}
-		results = append(results, group[1])
+		for ; scanned < group[2]; scanned += 1 {
+			if str[scanned] == '\n' {
+				line, line_start = line+1, scanned+1
+			}
+		}
+		results = append(results, token)
+		lines = append(lines, line)
+		columns = append(columns, group[2]-line_start+1)
 	}
-	return results
+	return results, lines, columns
 }
 
+// string_token matches a terminated string or regex literal
+var string_token = regexp.MustCompile(`^#?"(?:\\.|[^\\"])*"$`)
+
 func read_atom(rdr Reader) (MalType, error) {
 	token := rdr.next()
 	if token == nil {
 		return nil, errors.New("read_atom underflow")
 	}
+	if strings.HasPrefix(*token, `"`) || strings.HasPrefix(*token, `#"`) {
+		if !string_token.MatchString(*token) {
+			return nil, errors.New("expected '\"', got EOF")
+		}
+	}
 	if match, _ := regexp.MatchString(`^-?[0-9]+$`, *token); match {
 		var i int
 		var e error
@@ -65,6 +109,12 @@ func read_atom(rdr Reader) (MalType, error) {
 			return nil, errors.New("number parse error")
 		}
 		return i, nil
+	} else if match, _ := regexp.MatchString(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`, *token); match {
+		f, e := strconv.ParseFloat(*token, 64)
+		if e != nil {
+			return nil, errors.New("number parse error")
+		}
+		return f, nil
 	} else if (*token)[0] == '"' {
 		str := (*token)[1 : len(*token)-1]
 		return strings.Replace(
@@ -74,6 +124,9 @@ func read_atom(rdr Reader) (MalType, error) {
 			  `\"`, `"`, -1),
 			 `\n`, "\n", -1),
 			"\u029e", "\\", -1), nil
+	} else if strings.HasPrefix(*token, `#"`) {
+		// regex literals are compiled as they are read
+		return NewRegex(strings.Replace((*token)[2:len(*token)-1], `\"`, `"`, -1))
 	} else if (*token)[0] == ':' {
 		return NewKeyword((*token)[1:len(*token)])
 	} else if *token == "nil" {
@@ -88,7 +141,9 @@ func read_atom(rdr Reader) (MalType, error) {
 	return token, nil
 }
 
-func read_list(rdr Reader, start string, end string) (MalType, error) {
+// read_list reads the forms between start and end, appending the
+// source position of each to positions unless it is nil
+func read_list(rdr Reader, start string, end string, positions *[]MalType) (MalType, error) {
 	token := rdr.next()
 	if token == nil {
 		return nil, errors.New("read_list underflow")
@@ -106,6 +161,9 @@ func read_list(rdr Reader, start string, end string) (MalType, error) {
 		if *token == end {
 			break
 		}
+		if positions != nil {
+			*positions = append(*positions, rdr.loc())
+		}
 		f, e := read_form(rdr)
 		if e != nil {
 			return nil, e
@@ -117,7 +175,7 @@ func read_list(rdr Reader, start string, end string) (MalType, error) {
 }
 
 func read_vector(rdr Reader) (MalType, error) {
-	lst, e := read_list(rdr, "[", "]")
+	lst, e := read_list(rdr, "[", "]", nil)
 	if e != nil {
 		return nil, e
 	}
@@ -126,7 +184,7 @@ func read_vector(rdr Reader) (MalType, error) {
 }
 
 func read_hash_map(rdr Reader) (MalType, error) {
-	mal_lst, e := read_list(rdr, "{", "}")
+	mal_lst, e := read_list(rdr, "{", "}", nil)
 	if e != nil {
 		return nil, e
 	}
@@ -186,12 +244,40 @@ func read_form(rdr Reader) (MalType, error) {
 			return nil, e
 		}
 		return List{[]MalType{Symbol{"deref"}, form}, nil}, nil
+	case "#inst":
+		rdr.next()
+		form, e := read_form(rdr)
+		if e != nil {
+			return nil, e
+		}
+		str, ok := form.(string)
+		if !ok || Keyword_Q(str) {
+			return nil, errors.New("#inst expects a timestamp string")
+		}
+		t, e := time.Parse(time.RFC3339Nano, str)
+		if e != nil {
+			return nil, e
+		}
+		return Instant{t}, nil
 
 	// list
 	case ")":
 		return nil, errors.New("unexpected ')'")
 	case "(":
-		return read_list(rdr, "(", ")")
+		// lists carry their source position as metadata
+		loc := rdr.loc()
+		var positions *[]MalType
+		if ElementPositions && loc != nil {
+			positions = &[]MalType{}
+		}
+		lst, e := read_list(rdr, "(", ")", positions)
+		if e != nil {
+			return nil, e
+		}
+		if positions != nil {
+			loc.(HashMap).Val["\u029epositions"] = Vector{*positions, nil}
+		}
+		return List{lst.(List).Val, loc}, nil
 
 	// vector
 	case "]":
@@ -210,11 +296,40 @@ func read_form(rdr Reader) (MalType, error) {
 	return read_atom(rdr)
 }
 
+// ElementPositions makes lists also record the source position of each
+// of their elements, as a vector under :positions in their metadata,
+// for coverage reporting
+var ElementPositions bool
+
 func Read_str(str string) (MalType, error) {
-	var tokens = tokenize(str)
+	return Read_str_file(str, "")
+}
+
+// Read_str_file reads str as if it came from the named file, which is
+// recorded in the source positions of the lists read
+func Read_str_file(str string, file string) (MalType, error) {
+	var tokens, lines, columns = tokenize(str)
 	if len(tokens) == 0 {
 		return nil, errors.New("<empty line>")
 	}
 
-	return read_form(&TokenReader{tokens: tokens, position: 0})
+	return read_form(&TokenReader{tokens: tokens, lines: lines,
+		columns: columns, position: 0, file: file})
+}
+
+// Read_forms_file reads all the forms in str, as if it came from the
+// named file
+func Read_forms_file(str string, file string) ([]MalType, error) {
+	var tokens, lines, columns = tokenize(str)
+	rdr := &TokenReader{tokens: tokens, lines: lines,
+		columns: columns, position: 0, file: file}
+	forms := []MalType{}
+	for rdr.peek() != nil {
+		f, e := read_form(rdr)
+		if e != nil {
+			return nil, e
+		}
+		forms = append(forms, f)
+	}
+	return forms, nil
 }
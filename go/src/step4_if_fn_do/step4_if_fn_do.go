@@ -198,7 +198,10 @@ func rep(str string) (MalType, error) {
 func main() {
 	// core.go: defined using go
 	for k, v := range core.NS {
-		repl_env.Set(Symbol{k}, v)
+		fn := v.(func(*EvalContext, []MalType) (MalType, error))
+		repl_env.Set(Symbol{k}, func(a []MalType) (MalType, error) {
+			return fn(nil, a)
+		})
 	}
 
 	// core.mal: defined using the language itself
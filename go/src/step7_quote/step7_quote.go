@@ -190,7 +190,7 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				ast = a2
 			}
 		case "fn*":
-			fn := MalFunc{EVAL, a2, env, a1, false, NewEnv, nil}
+			fn := MalFunc{fn_eval, a2, env, a1, false, fn_env, nil, ""}
 			return fn, nil
 		default:
 			el, e := eval_ast(ast, env)
@@ -210,13 +210,23 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				if !ok {
 					return nil, errors.New("attempt to call non-function")
 				}
-				return fn.Fn(el.(List).Val[1:])
+				return fn.Fn(nil, el.(List).Val[1:])
 			}
 		}
 
 	} // TCO loop
 }
 
+// fn_eval and fn_env adapt EVAL and NewEnv to MalFunc, whose functions
+// take the context of the evaluation, which this step does not track
+func fn_eval(_ *EvalContext, ast MalType, env EnvType) (MalType, error) {
+	return EVAL(ast, env)
+}
+
+func fn_env(_ *EvalContext, outer EnvType, binds MalType, exprs MalType) (EnvType, error) {
+	return NewEnv(outer, binds, exprs)
+}
+
 // print
 func PRINT(exp MalType) (string, error) {
 	return printer.Pr_str(exp, true), nil
@@ -244,9 +254,9 @@ func rep(str string) (MalType, error) {
 func main() {
 	// core.go: defined using go
 	for k, v := range core.NS {
-		repl_env.Set(Symbol{k}, Func{v.(func([]MalType) (MalType, error)), nil})
+		repl_env.Set(Symbol{k}, Func{v.(func(*EvalContext, []MalType) (MalType, error)), nil})
 	}
-	repl_env.Set(Symbol{"eval"}, Func{func(a []MalType) (MalType, error) {
+	repl_env.Set(Symbol{"eval"}, Func{func(_ *EvalContext, a []MalType) (MalType, error) {
 		return EVAL(a[0], repl_env)
 	}, nil})
 	repl_env.Set(Symbol{"*ARGV*"}, List{})
@@ -84,7 +84,7 @@ func macroexpand(ast MalType, env EnvType) (MalType, error) {
 			return nil, e
 		}
 		fn := mac.(MalFunc)
-		ast, e = Apply(fn, slc[1:])
+		ast, e = Apply(nil, fn, slc[1:])
 		if e != nil {
 			return nil, e
 		}
@@ -230,10 +230,10 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				if a2 != nil && List_Q(a2) {
 					a2s, _ := GetSlice(a2)
 					if Symbol_Q(a2s[0]) && (a2s[0].(Symbol).Val == "catch*") {
-						switch e.(type) {
-						case MalError:
-							exc = e.(MalError).Obj
-						default:
+						var me MalError
+						if errors.As(e, &me) {
+							exc = me.Obj
+						} else {
 							exc = e.Error()
 						}
 						binds := NewList(a2s[1])
@@ -274,7 +274,7 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				ast = a2
 			}
 		case "fn*":
-			fn := MalFunc{EVAL, a2, env, a1, false, NewEnv, nil}
+			fn := MalFunc{fn_eval, a2, env, a1, false, fn_env, nil, ""}
 			return fn, nil
 		default:
 			el, e := eval_ast(ast, env)
@@ -294,13 +294,23 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				if !ok {
 					return nil, errors.New("attempt to call non-function")
 				}
-				return fn.Fn(el.(List).Val[1:])
+				return fn.Fn(nil, el.(List).Val[1:])
 			}
 		}
 
 	} // TCO loop
 }
 
+// fn_eval and fn_env adapt EVAL and NewEnv to MalFunc, whose functions
+// take the context of the evaluation, which this step does not track
+func fn_eval(_ *EvalContext, ast MalType, env EnvType) (MalType, error) {
+	return EVAL(ast, env)
+}
+
+func fn_env(_ *EvalContext, outer EnvType, binds MalType, exprs MalType) (EnvType, error) {
+	return NewEnv(outer, binds, exprs)
+}
+
 // print
 func PRINT(exp MalType) (string, error) {
 	return printer.Pr_str(exp, true), nil
@@ -328,9 +338,9 @@ func rep(str string) (MalType, error) {
 func main() {
 	// core.go: defined using go
 	for k, v := range core.NS {
-		repl_env.Set(Symbol{k}, Func{v.(func([]MalType) (MalType, error)), nil})
+		repl_env.Set(Symbol{k}, Func{v.(func(*EvalContext, []MalType) (MalType, error)), nil})
 	}
-	repl_env.Set(Symbol{"eval"}, Func{func(a []MalType) (MalType, error) {
+	repl_env.Set(Symbol{"eval"}, Func{func(_ *EvalContext, a []MalType) (MalType, error) {
 		return EVAL(a[0], repl_env)
 	}, nil})
 	repl_env.Set(Symbol{"*ARGV*"}, List{})
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+import (
+	"core"
+	"reader"
+	. "types"
+)
+
+// Line coverage. While collecting, the lists read from a file that are
+// evaluated as code are registered with their source positions and EVAL
+// counts how often each one is evaluated. The arguments of cond and if
+// that are atoms are counted too, from a table of their positions that
+// EVAL looks up by the identity of the form, and which follows a cond
+// through its expansion. A line is covered when every form starting on
+// it was evaluated, so a line holding both branches of an if or a cond
+// clause is only covered once both have run.
+
+type cover_pos struct {
+	file   string
+	line   int
+	column int
+}
+
+// cover_counts is nil unless coverage is being collected
+var cover_counts map[cover_pos]int
+
+// cover_atoms holds the element positions of the cond and if forms
+// with atoms among their arguments
+var cover_atoms map[form_id][]MalType
+
+func cover_position(ast MalType) (cover_pos, bool) {
+	lst, ok := ast.(List)
+	if !ok {
+		return cover_pos{}, false
+	}
+	return meta_position(lst.Meta)
+}
+
+// meta_position returns the position held by the metadata of a list or
+// by an element of its :positions
+func meta_position(meta MalType) (cover_pos, bool) {
+	m, ok := meta.(HashMap)
+	if !ok {
+		return cover_pos{}, false
+	}
+	file, ok := m.Val["\u029efile"].(string)
+	if !ok {
+		return cover_pos{}, false
+	}
+	line, _ := m.Val["\u029eline"].(int)
+	column, _ := m.Val["\u029ecolumn"].(int)
+	return cover_pos{file, line, column}, true
+}
+
+// cover_register records the lists in ast as coverable, skipping those
+// special forms do not evaluate (quoted data, parameters, binding
+// patterns)
+func cover_register(ast MalType) {
+	if pos, ok := cover_position(ast); ok {
+		if _, seen := cover_counts[pos]; !seen {
+			cover_counts[pos] = 0
+		}
+	}
+	switch tobj := ast.(type) {
+	case List:
+		forms := tobj.Val
+		if len(forms) > 0 && Symbol_Q(forms[0]) {
+			switch forms[0].(Symbol).Val {
+			case "quote":
+				return
+			case "quasiquote":
+				cover_register_unquoted(forms[1:])
+				return
+			case "cond", "if":
+				cover_branches(tobj)
+			case "fn*", "def!", "defmacro!", "catch*":
+				if len(forms) > 2 {
+					forms = forms[2:]
+				} else {
+					forms = nil
+				}
+			case "let*", "binding":
+				if len(forms) > 1 {
+					if binds, e := GetSlice(forms[1]); e == nil {
+						for i := 1; i < len(binds); i += 2 {
+							cover_register(binds[i])
+						}
+					}
+					forms = forms[2:]
+				}
+			}
+		}
+		for _, a := range forms {
+			cover_register(a)
+		}
+	case Vector:
+		for _, a := range tobj.Val {
+			cover_register(a)
+		}
+	case HashMap:
+		for _, a := range tobj.Val {
+			cover_register(a)
+		}
+	}
+}
+
+// cover_branches registers the atoms among the arguments of a cond or
+// an if with their source positions
+func cover_branches(lst List) {
+	m, ok := lst.Meta.(HashMap)
+	if !ok {
+		return
+	}
+	positions, ok := m.Val["\u029epositions"].(Vector)
+	if !ok {
+		return
+	}
+	atoms := false
+	for i := 1; i < len(lst.Val) && i < len(positions.Val); i += 1 {
+		if List_Q(lst.Val[i]) {
+			continue
+		}
+		if pos, ok := meta_position(positions.Val[i]); ok {
+			if _, seen := cover_counts[pos]; !seen {
+				cover_counts[pos] = 0
+			}
+			atoms = true
+		}
+	}
+	if atoms {
+		cover_atoms[form_identity(lst)] = positions.Val
+	}
+}
+
+// cover_expansion gives the expansion of a registered cond, an if whose
+// else branch is a cond of the remaining clauses, the positions of the
+// clauses it took over
+func cover_expansion(form MalType, exp MalType) {
+	positions, ok := cover_atoms[form_identity(form)]
+	if !ok || len(positions) < 3 {
+		return
+	}
+	if sym, ok := form.(List).Val[0].(Symbol); !ok || sym.Val != "cond" {
+		return
+	}
+	lst, ok := exp.(List)
+	if !ok || len(lst.Val) != 4 || !List_Q(lst.Val[3]) {
+		return
+	}
+	cover_atoms[form_identity(lst)] = positions[:3]
+	cover_atoms[form_identity(lst.Val[3])] = append([]MalType{nil}, positions[3:]...)
+}
+
+func cover_register_unquoted(forms []MalType) {
+	for _, a := range forms {
+		slc, e := GetSlice(a)
+		if e != nil || len(slc) == 0 {
+			continue
+		}
+		if sym, ok := slc[0].(Symbol); ok &&
+			(sym.Val == "unquote" || sym.Val == "splice-unquote") {
+			for _, u := range slc[1:] {
+				cover_register(u)
+			}
+		} else {
+			cover_register_unquoted(slc)
+		}
+	}
+}
+
+func cover_mark(ast MalType) {
+	if pos, ok := cover_position(ast); ok {
+		cover_counts[pos] += 1
+	}
+}
+
+// cover_mark_if counts the evaluation of the test of an if and of the
+// branch taken when they are atoms
+func cover_mark_if(ast MalType, taken_else bool) {
+	lst := ast.(List)
+	positions, ok := cover_atoms[form_identity(lst)]
+	if !ok {
+		return
+	}
+	branch := 2
+	if taken_else {
+		branch = 3
+	}
+	for _, i := range []int{1, branch} {
+		if i < len(lst.Val) && i < len(positions) && !List_Q(lst.Val[i]) {
+			if pos, ok := meta_position(positions[i]); ok {
+				cover_counts[pos] += 1
+			}
+		}
+	}
+}
+
+// cover_start starts collecting coverage
+func cover_start() {
+	cover_counts = map[cover_pos]int{}
+	cover_atoms = map[form_id][]MalType{}
+	reader.ElementPositions = true
+	repl_env.Set(Symbol{"read-forms"}, Func{cover_read_forms, nil})
+}
+
+// cover_read_forms replaces read-forms while collecting coverage so
+// that the forms of loaded files are registered
+func cover_read_forms(ec *EvalContext, a []MalType) (MalType, error) {
+	forms, e := core.NS["read-forms"].(func(*EvalContext, []MalType) (MalType, error))(ec, a)
+	if e == nil && len(a) == 2 {
+		for _, f := range forms.(List).Val {
+			cover_register(f)
+		}
+	}
+	return forms, e
+}
+
+// cover_lines returns, per file, the execution count of each line with
+// coverable forms
+func cover_lines() map[string]map[int]int {
+	files := map[string]map[int]int{}
+	for pos, n := range cover_counts {
+		lines, ok := files[pos.file]
+		if !ok {
+			lines = map[int]int{}
+			files[pos.file] = lines
+		}
+		if m, ok := lines[pos.line]; !ok || n < m {
+			lines[pos.line] = n
+		}
+	}
+	return files
+}
+
+func sorted_keys(m map[string]map[int]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cover_write_lcov writes the coverage in lcov tracefile format
+func cover_write_lcov(w io.Writer) error {
+	files := cover_lines()
+	for _, file := range sorted_keys(files) {
+		lines := files[file]
+		nums := make([]int, 0, len(lines))
+		for l := range lines {
+			nums = append(nums, l)
+		}
+		sort.Ints(nums)
+		if _, e := fmt.Fprintf(w, "TN:\nSF:%s\n", file); e != nil {
+			return e
+		}
+		hit := 0
+		for _, l := range nums {
+			if lines[l] > 0 {
+				hit += 1
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", l, lines[l])
+		}
+		if _, e := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(nums), hit); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// cover_summary writes the percentage of lines covered in each file
+func cover_summary(w io.Writer) {
+	files := cover_lines()
+	for _, file := range sorted_keys(files) {
+		hit := 0
+		for _, n := range files[file] {
+			if n > 0 {
+				hit += 1
+			}
+		}
+		total := len(files[file])
+		if total == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %d/%d lines (%.1f%%)\n", file, hit, total,
+			100*float64(hit)/float64(total))
+	}
+}
+
+func cover_save(path string) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	if e = cover_write_lcov(f); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
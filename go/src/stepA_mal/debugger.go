@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+import (
+	. "env"
+	"printer"
+	"readline"
+	. "types"
+)
+
+// Step debugger. EVAL calls debug_check before evaluating each list
+// form; when the debugger decides to stop there it opens a nested REPL
+// on the form's environment.
+
+const (
+	debug_run    = iota // only stop at breakpoints and (break)
+	debug_step          // stop at the next form
+	debug_next          // stop at the next form at or above depth
+	debug_finish        // stop at the next form above depth
+)
+
+var dbg = struct {
+	mode        int
+	depth       int
+	active      bool
+	breakpoints map[string]bool
+}{debug_run, 0, false, map[string]bool{}}
+
+// debug_aborted is returned by :quit. Like a LimitError it cannot be
+// caught, so the evaluation unwinds to the REPL.
+var debug_aborted = errors.New("debugger: evaluation aborted")
+
+// debug_readline reads the commands of the debugger
+var debug_readline = readline.Readline
+
+func debug_check(ec *EvalContext, ast MalType, env EnvType) error {
+	switch dbg.mode {
+	case debug_run:
+		return nil
+	case debug_next:
+		if ec.StackDepth() > dbg.depth {
+			return nil
+		}
+	case debug_finish:
+		if ec.StackDepth() >= dbg.depth {
+			return nil
+		}
+	}
+	return debug_repl(ec, ast, env)
+}
+
+// debug_enter stops at the body of a function with a breakpoint
+func debug_enter(name string) {
+	if dbg.breakpoints[name] && !dbg.active {
+		dbg.mode = debug_step
+	}
+}
+
+const debug_help = `:c  continue        :s  step into       :n  step over
+:o  step out        :l  locals          :bt call stack
+:f  current form    :q  abort           :h  this help
+anything else is evaluated in the current environment`
+
+func debug_repl(ec *EvalContext, ast MalType, env EnvType) error {
+	if dbg.active {
+		return nil
+	}
+	dbg.active = true
+	defer func() { dbg.active = false }()
+
+	fmt.Printf("-> %s\n", debug_form(ast))
+	for {
+		text, err := debug_readline("debug> ")
+		if err != nil {
+			dbg.mode = debug_run
+			return nil
+		}
+		switch strings.TrimSpace(text) {
+		case "":
+		case ":c", ":continue":
+			dbg.mode = debug_run
+			return nil
+		case ":s", ":step":
+			dbg.mode = debug_step
+			return nil
+		case ":n", ":next":
+			dbg.mode, dbg.depth = debug_next, ec.StackDepth()
+			return nil
+		case ":o", ":out":
+			dbg.mode, dbg.depth = debug_finish, ec.StackDepth()
+			return nil
+		case ":q", ":quit":
+			dbg.mode = debug_run
+			return debug_aborted
+		case ":l", ":locals":
+			locals := Locals(ec, env)
+			names := make([]string, 0, len(locals))
+			for k := range locals {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				fmt.Printf("  %s = %s\n", k, printer.Pr_str(locals[k], true))
+			}
+		case ":bt", ":stack":
+			stack := ec.CallStack()
+			for i := len(stack) - 1; i >= 0; i -= 1 {
+				fmt.Printf("  %s\n", stack[i])
+			}
+		case ":f", ":form":
+			fmt.Printf("-> %s\n", debug_form(ast))
+		case ":h", ":help":
+			fmt.Println(debug_help)
+		default:
+			exp, e := READ(text)
+			if e == nil {
+				exp, e = EVAL(ec, exp, env)
+			}
+			if e != nil {
+				fmt.Printf("Error: %v\n", e)
+				continue
+			}
+			fmt.Println(printer.Pr_str(exp, true))
+		}
+	}
+}
+
+// debug_form describes ast and where it was read from
+func debug_form(ast MalType) string {
+	if loc := SourceLocation(ast); loc != "" {
+		return printer.Pr_str(ast, true) + " (" + loc + ")"
+	}
+	return printer.Pr_str(ast, true)
+}
+
+func breakpoint_name(a []MalType) (string, error) {
+	if len(a) != 1 {
+		return "", NewArityError("wrong number of arguments (%d instead of 1)", len(a))
+	}
+	switch name := a[0].(type) {
+	case Symbol:
+		return name.Val, nil
+	case string:
+		return name, nil
+	}
+	return "", NewTypeError("breakpoint: expected symbol or string")
+}
+
+func set_breakpoint(_ *EvalContext, a []MalType) (MalType, error) {
+	name, e := breakpoint_name(a)
+	if e != nil {
+		return nil, e
+	}
+	dbg.breakpoints[name] = true
+	// also stop in functions called by core functions such as map
+	CallHook = debug_enter
+	return nil, nil
+}
+
+func clear_breakpoint(_ *EvalContext, a []MalType) (MalType, error) {
+	name, e := breakpoint_name(a)
+	if e != nil {
+		return nil, e
+	}
+	delete(dbg.breakpoints, name)
+	if len(dbg.breakpoints) == 0 {
+		CallHook = nil
+	}
+	return nil, nil
+}
+
+func breakpoints(_ *EvalContext, a []MalType) (MalType, error) {
+	names := []string{}
+	for k := range dbg.breakpoints {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	lst := []MalType{}
+	for _, k := range names {
+		lst = append(lst, Symbol{k})
+	}
+	return List{lst, nil}, nil
+}
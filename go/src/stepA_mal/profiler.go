@@ -0,0 +1,278 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+import (
+	. "types"
+)
+
+// Call profiler. While profiling, types.FrameHook times every frame
+// pushed onto the call stack. Time is accumulated per function name
+// (inclusive and exclusive of callees) and per call path, from which a
+// pprof profile with mal-level frames can be written.
+
+type prof_node struct {
+	name     string
+	calls    int
+	excl     time.Duration
+	children map[string]*prof_node
+}
+
+type prof_entry struct {
+	node     *prof_node
+	start    time.Time
+	children time.Duration
+}
+
+type prof_stat struct {
+	calls int
+	incl  time.Duration
+	excl  time.Duration
+}
+
+type profile struct {
+	root    *prof_node
+	entries []prof_entry
+	stats   map[string]*prof_stat
+	active  map[string]int
+	start   time.Time
+	elapsed time.Duration
+}
+
+var prof *profile
+
+func frame_name(f Frame) string {
+	if f.Name == "" {
+		return "<anonymous>"
+	}
+	return f.Name
+}
+
+func prof_start() *profile {
+	p := &profile{
+		root:   &prof_node{"", 0, 0, map[string]*prof_node{}},
+		stats:  map[string]*prof_stat{},
+		active: map[string]int{},
+		start:  time.Now(),
+	}
+	prof = p
+	FrameHook = prof_hook
+	return p
+}
+
+func prof_stop(p *profile) {
+	p.elapsed = time.Since(p.start)
+	FrameHook = nil
+	prof = nil
+}
+
+func prof_hook(f Frame, enter bool) {
+	now := time.Now()
+	name := frame_name(f)
+	if enter {
+		parent := prof.root
+		if len(prof.entries) > 0 {
+			parent = prof.entries[len(prof.entries)-1].node
+		}
+		node, ok := parent.children[name]
+		if !ok {
+			node = &prof_node{name, 0, 0, map[string]*prof_node{}}
+			parent.children[name] = node
+		}
+		node.calls += 1
+		st, ok := prof.stats[name]
+		if !ok {
+			st = &prof_stat{}
+			prof.stats[name] = st
+		}
+		st.calls += 1
+		prof.active[name] += 1
+		prof.entries = append(prof.entries, prof_entry{node, now, 0})
+		return
+	}
+	if len(prof.entries) == 0 {
+		// a frame pushed before profiling started
+		return
+	}
+	top := prof.entries[len(prof.entries)-1]
+	prof.entries = prof.entries[:len(prof.entries)-1]
+	incl := now.Sub(top.start)
+	excl := incl - top.children
+	top.node.excl += excl
+	st := prof.stats[name]
+	st.excl += excl
+	// count recursive calls once towards inclusive time
+	if prof.active[name] -= 1; prof.active[name] == 0 {
+		st.incl += incl
+	}
+	if len(prof.entries) > 0 {
+		prof.entries[len(prof.entries)-1].children += incl
+	}
+}
+
+// prof_report writes a table of the functions called, most expensive
+// (inclusive) first
+func prof_report(w io.Writer, p *profile) {
+	names := make([]string, 0, len(p.stats))
+	for k := range p.stats {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.stats[names[i]], p.stats[names[j]]
+		if a.incl != b.incl {
+			return a.incl > b.incl
+		}
+		return names[i] < names[j]
+	})
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	fmt.Fprintf(w, "%10s %12s %12s  %s\n", "calls", "incl ms", "excl ms", "function")
+	for _, k := range names {
+		st := p.stats[k]
+		fmt.Fprintf(w, "%10d %12.3f %12.3f  %s\n", st.calls, ms(st.incl), ms(st.excl), k)
+	}
+	fmt.Fprintf(w, "total %.3f ms\n", ms(p.elapsed))
+}
+
+// profile_call calls a function of no arguments with profiling enabled
+// and prints a report
+func profile_call(ec *EvalContext, a []MalType) (MalType, error) {
+	if len(a) != 1 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 1)", len(a))
+	}
+	if prof != nil {
+		// already profiling, e.g. under --profile
+		return Apply(ec, a[0], []MalType{})
+	}
+	p := prof_start()
+	res, e := Apply(ec, a[0], []MalType{})
+	prof_stop(p)
+	prof_report(os.Stdout, p)
+	return res, e
+}
+
+// Minimal protocol buffer encoding of the pprof profile.proto format
+
+type proto_buf []byte
+
+func (b *proto_buf) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *proto_buf) int_field(tag int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(tag) << 3)
+	b.varint(uint64(x))
+}
+
+func (b *proto_buf) bytes_field(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *proto_buf) packed_field(tag int, xs []int64) {
+	var p proto_buf
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytes_field(tag, p)
+}
+
+// prof_write_pprof writes p as a gzipped pprof profile with a sample
+// per call path valued by its call count and exclusive time
+func prof_write_pprof(w io.Writer, p *profile) error {
+	strs := []string{""}
+	str_idx := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := str_idx[s]; ok {
+			return i
+		}
+		str_idx[s] = int64(len(strs))
+		strs = append(strs, s)
+		return str_idx[s]
+	}
+	value_type := func(typ, unit string) proto_buf {
+		var vt proto_buf
+		vt.int_field(1, str(typ))
+		vt.int_field(2, str(unit))
+		return vt
+	}
+
+	var out proto_buf
+	out.bytes_field(1, value_type("calls", "count"))
+	out.bytes_field(1, value_type("time", "nanoseconds"))
+
+	// one function and location per name
+	func_ids := map[string]int64{}
+	var walk func(n *prof_node, stack []int64)
+	walk = func(n *prof_node, stack []int64) {
+		id, ok := func_ids[n.name]
+		if !ok {
+			id = int64(len(func_ids) + 1)
+			func_ids[n.name] = id
+		}
+		// locations are listed leaf first
+		stack = append([]int64{id}, stack...)
+		var sample proto_buf
+		sample.packed_field(1, stack)
+		sample.packed_field(2, []int64{int64(n.calls), int64(n.excl)})
+		out.bytes_field(2, sample)
+		for _, c := range n.children {
+			walk(c, stack)
+		}
+	}
+	for _, c := range p.root.children {
+		walk(c, nil)
+	}
+	for name, id := range func_ids {
+		var line proto_buf
+		line.int_field(1, id)
+		var loc proto_buf
+		loc.int_field(1, id)
+		loc.bytes_field(4, line)
+		out.bytes_field(4, loc)
+		var fn proto_buf
+		fn.int_field(1, id)
+		fn.int_field(2, str(name))
+		fn.int_field(3, str(name))
+		out.bytes_field(5, fn)
+	}
+	out.int_field(9, p.start.UnixNano())
+	out.int_field(10, int64(p.elapsed))
+	out.bytes_field(11, value_type("time", "nanoseconds"))
+	// string_table must be the last use of str
+	for _, s := range strs {
+		out.bytes_field(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, e := zw.Write(out); e != nil {
+		return e
+	}
+	return zw.Close()
+}
+
+func prof_save(path string, p *profile) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	if e = prof_write_pprof(f, p); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strings"
+	"time"
 )
 
 import (
@@ -30,9 +35,28 @@ func is_pair(x MalType) bool {
 	return len(slc) > 0
 }
 
-func quasiquote(ast MalType) MalType {
+var auto_gensym_counter int
+
+// auto_gensym replaces a symbol ending in # (other than # itself) by a
+// fresh symbol, the same one throughout a syntax-quote template
+func auto_gensym(ast MalType, syms map[string]Symbol) MalType {
+	sym, ok := ast.(Symbol)
+	if !ok || len(sym.Val) < 2 || !strings.HasSuffix(sym.Val, "#") {
+		return ast
+	}
+	gen, ok := syms[sym.Val]
+	if !ok {
+		auto_gensym_counter += 1
+		gen = Symbol{fmt.Sprintf("%s__%d__auto__",
+			strings.TrimSuffix(sym.Val, "#"), auto_gensym_counter)}
+		syms[sym.Val] = gen
+	}
+	return gen
+}
+
+func quasiquote(ast MalType, syms map[string]Symbol) MalType {
 	if !is_pair(ast) {
-		return List{[]MalType{Symbol{"quote"}, ast}, nil}
+		return List{[]MalType{Symbol{"quote"}, auto_gensym(ast, syms)}, nil}
 	} else {
 		slc, _ := GetSlice(ast)
 		a0 := slc[0]
@@ -44,15 +68,20 @@ func quasiquote(ast MalType) MalType {
 			if Symbol_Q(a00) && (a00.(Symbol).Val == "splice-unquote") {
 				return List{[]MalType{Symbol{"concat"},
 					slc0[1],
-					quasiquote(List{slc[1:], nil})}, nil}
+					quasiquote(List{slc[1:], nil}, syms)}, nil}
 			}
 		}
 		return List{[]MalType{Symbol{"cons"},
-			quasiquote(a0),
-			quasiquote(List{slc[1:], nil})}, nil}
+			quasiquote(a0, syms),
+			quasiquote(List{slc[1:], nil}, syms)}, nil}
 	}
 }
 
+func is_macro(obj MalType) bool {
+	fn, ok := obj.(MalFunc)
+	return ok && fn.GetMacro()
+}
+
 func is_macro_call(ast MalType, env EnvType) bool {
 	if List_Q(ast) {
 		slc, _ := GetSlice(ast)
@@ -73,7 +102,7 @@ func is_macro_call(ast MalType, env EnvType) bool {
 	return false
 }
 
-func macroexpand(ast MalType, env EnvType) (MalType, error) {
+func macroexpand(ec *EvalContext, ast MalType, env EnvType) (MalType, error) {
 	var mac MalType
 	var e error
 	for is_macro_call(ast, env) {
@@ -84,7 +113,7 @@ func macroexpand(ast MalType, env EnvType) (MalType, error) {
 			return nil, e
 		}
 		fn := mac.(MalFunc)
-		ast, e = Apply(fn, slc[1:])
+		ast, e = Apply(ec, fn, slc[1:])
 		if e != nil {
 			return nil, e
 		}
@@ -92,14 +121,263 @@ func macroexpand(ast MalType, env EnvType) (MalType, error) {
 	return ast, nil
 }
 
-func eval_ast(ast MalType, env EnvType) (MalType, error) {
+// Macro expansions are cached per call site so that a macro call in a
+// function body is expanded once rather than on every call. A form is
+// identified by the backing array of its elements, which is shared by
+// every copy of the List, and an expansion is only reused while the
+// form's head still resolves to the macro that produced it.
+
+type form_id struct {
+	elems *MalType
+	n     int
+}
+
+func form_identity(ast MalType) form_id {
+	lst, ok := ast.(List)
+	if !ok || len(lst.Val) == 0 {
+		return form_id{}
+	}
+	return form_id{&lst.Val[0], len(lst.Val)}
+}
+
+type macro_expansion struct {
+	macro MalFunc // macro at the head of the form
+	exp   MalType
+}
+
+// the cache is emptied when full so that forms built at run time and
+// evaluated once do not accumulate
+const macro_cache_size = 10000
+
+var macro_cache = map[form_id]macro_expansion{}
+
+// macro_caching can be turned off to compare performance without the
+// cache
+var macro_caching = true
+
+func clear_macro_cache() {
+	macro_cache = map[form_id]macro_expansion{}
+}
+
+// same_macro reports whether a and b close over the same fn* form and
+// environment, and so expand alike. This is Equal_Q on functions without
+// walking list bodies, which are compared by identity.
+func same_macro(a, b MalFunc) bool {
+	body := form_identity(a.Exp)
+	if body != form_identity(b.Exp) || body == (form_id{}) && !Equal_Q(a.Exp, b.Exp) {
+		return false
+	}
+	env, ok := a.Env.(Env)
+	return ok && env.Same(b.Env) && Equal_Q(a.Params, b.Params)
+}
+
+func macroexpand_cached(ec *EvalContext, ast MalType, env EnvType) (MalType, error) {
+	if !macro_caching {
+		return macroexpand(ec, ast, env)
+	}
+	if !is_macro_call(ast, env) {
+		return ast, nil
+	}
+	mac, e := env.Get(ast.(List).Val[0].(Symbol))
+	if e != nil {
+		return nil, e
+	}
+	id := form_identity(ast)
+	if c, ok := macro_cache[id]; ok && same_macro(c.macro, mac.(MalFunc)) {
+		return c.exp, nil
+	}
+	exp, e := macroexpand(ec, ast, env)
+	if e != nil {
+		return nil, e
+	}
+	if len(macro_cache) >= macro_cache_size {
+		clear_macro_cache()
+	}
+	macro_cache[id] = macro_expansion{mac.(MalFunc), exp}
+	return exp, nil
+}
+
+// env_size is what an environment is charged to the allocation budget:
+// the Env and the map of its bindings, which Go allocates with room for
+// eight of them
+const env_size = 256
+
+// new_env creates an environment nested in outer as part of the
+// evaluation ec
+func new_env(ec *EvalContext, outer EnvType) (EnvType, error) {
+	if e := ec.Alloc(env_size); e != nil {
+		return nil, e
+	}
+	return NewEnv(outer, nil, nil)
+}
+
+// bind_env creates the environment for a function call, destructuring
+// the arguments according to the parameter pattern
+func bind_env(ec *EvalContext, outer EnvType, params MalType, args MalType) (EnvType, error) {
+	env, e := new_env(ec, outer)
+	if e != nil {
+		return nil, e
+	}
+	if e = Bind(ec, env, params, args, EVAL); e != nil {
+		return nil, e
+	}
+	return env, nil
+}
+
+// def_target returns the symbol defined by def! and whether it was
+// marked ^:dynamic
+func def_target(a1 MalType) (Symbol, bool, error) {
+	switch t := a1.(type) {
+	case Symbol:
+		return t, false, nil
+	case List:
+		// ^:dynamic sym is read as (with-meta sym :dynamic)
+		if len(t.Val) == 3 && Symbol_Q(t.Val[0]) &&
+			t.Val[0].(Symbol).Val == "with-meta" && Symbol_Q(t.Val[1]) {
+			sym := t.Val[1].(Symbol)
+			switch m := t.Val[2].(type) {
+			case string:
+				return sym, m == "\u029edynamic", nil
+			case HashMap:
+				return sym, True_Q(m.Val["\u029edynamic"]), nil
+			}
+			return sym, false, nil
+		}
+	}
+	return Symbol{}, false, errors.New("def! requires a symbol")
+}
+
+// eval_binding evaluates body with the dynamic vars in frame rebound,
+// restoring them however body exits
+func eval_binding(ec *EvalContext, frame map[*Var]MalType, body []MalType, env EnvType) (MalType, error) {
+	ec.PushBindings(frame)
+	defer ec.PopBindings()
+	el, e := eval_ast(ec, List{body, nil}, env)
+	if e != nil {
+		return nil, e
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+	return el.(List).Val[len(body)-1], nil
+}
+
+func macroexpand_1(ec *EvalContext, ast MalType, env EnvType) (MalType, error) {
+	if !is_macro_call(ast, env) {
+		return ast, nil
+	}
+	slc, _ := GetSlice(ast)
+	mac, e := env.Get(slc[0].(Symbol))
+	if e != nil {
+		return nil, e
+	}
+	return Apply(ec, mac, slc[1:])
+}
+
+// macroexpand_all expands macros in ast and, recursively, in its
+// subforms, leaving alone what special forms do not evaluate: quoted
+// data, fn* parameters, binding patterns and catch* bindings
+func macroexpand_all(ec *EvalContext, ast MalType, env EnvType) (MalType, error) {
+	ast, e := macroexpand(ec, ast, env)
+	if e != nil {
+		return nil, e
+	}
+	switch tobj := ast.(type) {
+	case List:
+		return macroexpand_list(ec, tobj, env)
+	case Vector:
+		slc, e := macroexpand_forms(ec, tobj.Val, env)
+		if e != nil {
+			return nil, e
+		}
+		return Vector{slc, tobj.Meta}, nil
+	case HashMap:
+		m := map[string]MalType{}
+		for k, v := range tobj.Val {
+			if m[k], e = macroexpand_all(ec, v, env); e != nil {
+				return nil, e
+			}
+		}
+		return HashMap{m, tobj.Meta}, nil
+	}
+	return ast, nil
+}
+
+func macroexpand_forms(ec *EvalContext, forms []MalType, env EnvType) ([]MalType, error) {
+	slc := make([]MalType, len(forms))
+	for i, f := range forms {
+		var e error
+		if slc[i], e = macroexpand_all(ec, f, env); e != nil {
+			return nil, e
+		}
+	}
+	return slc, nil
+}
+
+func macroexpand_list(ec *EvalContext, lst List, env EnvType) (MalType, error) {
+	forms := lst.Val
+	// number of leading forms kept as they are
+	keep := 0
+	if len(forms) > 0 && Symbol_Q(forms[0]) {
+		switch forms[0].(Symbol).Val {
+		case "quote", "quasiquote":
+			return lst, nil
+		case "def!", "defmacro!", "fn*", "catch*":
+			keep = 2
+		case "let*", "binding":
+			if len(forms) < 2 {
+				break
+			}
+			binds, e := GetSlice(forms[1])
+			if e != nil {
+				return nil, e
+			}
+			new_binds := make([]MalType, len(binds))
+			for i, b := range binds {
+				new_binds[i] = b
+				if i%2 == 1 {
+					if new_binds[i], e = macroexpand_all(ec, b, env); e != nil {
+						return nil, e
+					}
+				}
+			}
+			rest, e := macroexpand_forms(ec, forms[2:], env)
+			if e != nil {
+				return nil, e
+			}
+			var new_bind_form MalType = List{new_binds, nil}
+			if Vector_Q(forms[1]) {
+				new_bind_form = Vector{new_binds, nil}
+			}
+			return List{append([]MalType{forms[0], new_bind_form}, rest...), lst.Meta}, nil
+		}
+	}
+	if keep > len(forms) {
+		keep = len(forms)
+	}
+	rest, e := macroexpand_forms(ec, forms[keep:], env)
+	if e != nil {
+		return nil, e
+	}
+	slc := append(append([]MalType{}, forms[:keep]...), rest...)
+	return List{slc, lst.Meta}, nil
+}
+
+func eval_ast(ec *EvalContext, ast MalType, env EnvType) (MalType, error) {
 	//fmt.Printf("eval_ast: %#v\n", ast)
 	if Symbol_Q(ast) {
-		return env.Get(ast.(Symbol))
+		val, e := env.Get(ast.(Symbol))
+		if v, ok := val.(*Var); ok {
+			return ec.Deref(v), e
+		}
+		return val, e
 	} else if List_Q(ast) {
+		if e := ec.Alloc(uint64(len(ast.(List).Val)) * SlotSize); e != nil {
+			return nil, e
+		}
 		lst := []MalType{}
 		for _, a := range ast.(List).Val {
-			exp, e := EVAL(a, env)
+			exp, e := EVAL(ec, a, env)
 			if e != nil {
 				return nil, e
 			}
@@ -107,9 +385,12 @@ func eval_ast(ast MalType, env EnvType) (MalType, error) {
 		}
 		return List{lst, nil}, nil
 	} else if Vector_Q(ast) {
+		if e := ec.Alloc(uint64(len(ast.(Vector).Val)) * SlotSize); e != nil {
+			return nil, e
+		}
 		lst := []MalType{}
 		for _, a := range ast.(Vector).Val {
-			exp, e := EVAL(a, env)
+			exp, e := EVAL(ec, a, env)
 			if e != nil {
 				return nil, e
 			}
@@ -118,16 +399,19 @@ func eval_ast(ast MalType, env EnvType) (MalType, error) {
 		return Vector{lst, nil}, nil
 	} else if HashMap_Q(ast) {
 		m := ast.(HashMap)
+		if e := ec.Alloc(2 * uint64(len(m.Val)) * SlotSize); e != nil {
+			return nil, e
+		}
 		new_hm := HashMap{map[string]MalType{}, nil}
 		for k, v := range m.Val {
-			ke, e1 := EVAL(k, env)
+			ke, e1 := EVAL(ec, k, env)
 			if e1 != nil {
 				return nil, e1
 			}
 			if _, ok := ke.(string); !ok {
 				return nil, errors.New("non string hash-map key")
 			}
-			kv, e2 := EVAL(v, env)
+			kv, e2 := EVAL(ec, v, env)
 			if e2 != nil {
 				return nil, e2
 			}
@@ -139,28 +423,66 @@ func eval_ast(ast MalType, env EnvType) (MalType, error) {
 	}
 }
 
-func EVAL(ast MalType, env EnvType) (MalType, error) {
+// enter_frame records a call made by the EVAL invocation whose frames
+// start at base, replacing its previous frame on a tail call
+func enter_frame(ec *EvalContext, base int, name string, form MalType) {
+	ec.PopFrames(base)
+	ec.PushFrame(Frame{name, form})
+}
+
+// EVAL evaluates ast in env as part of the evaluation ec
+func EVAL(ec *EvalContext, ast MalType, env EnvType) (res MalType, err error) {
+	base := len(ec.CallStack())
+	if err = ec.EnterStack(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = ec.WithStack(err)
+		}
+		ec.PopFrames(base)
+		ec.LeaveStack()
+		ec.Leave()
+	}()
+	if err = ec.Enter(); err != nil {
+		return nil, err
+	}
 	var e error
 	for {
+		if e = ec.Step(); e != nil {
+			return nil, e
+		}
 
 		//fmt.Printf("EVAL: %v\n", printer.Pr_str(ast, true))
 		switch ast.(type) {
 		case List: // continue
 		default:
-			return eval_ast(ast, env)
+			return eval_ast(ec, ast, env)
+		}
+		if cover_counts != nil {
+			cover_mark(ast)
 		}
 
 		// apply list
-		ast, e = macroexpand(ast, env)
+		form := ast
+		ast, e = macroexpand_cached(ec, ast, env)
 		if e != nil {
 			return nil, e
 		}
+		if cover_counts != nil {
+			cover_expansion(form, ast)
+		}
 		if !List_Q(ast) {
-			return eval_ast(ast, env)
+			return eval_ast(ec, ast, env)
 		}
 		if len(ast.(List).Val) == 0 {
 			return ast, nil
 		}
+		if dbg.mode != debug_run {
+			if e = debug_check(ec, ast, env); e != nil {
+				return nil, e
+			}
+		}
 
 		a0 := ast.(List).Val[0]
 		var a1 MalType = nil
@@ -182,13 +504,28 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 		}
 		switch a0sym {
 		case "def!":
-			res, e := EVAL(a2, env)
+			sym, dynamic, e := def_target(a1)
+			if e != nil {
+				return nil, e
+			}
+			res, e := EVAL(ec, a2, env)
 			if e != nil {
 				return nil, e
 			}
-			return env.Set(a1.(Symbol), res), nil
+			if fn, ok := res.(MalFunc); ok && fn.Name == "" {
+				fn.Name = sym.Val
+				res = fn
+			}
+			if old, e := env.Get(sym); is_macro(res) || (e == nil && is_macro(old)) {
+				clear_macro_cache()
+			}
+			if dynamic {
+				env.Set(sym, &Var{sym, res, nil})
+				return res, nil
+			}
+			return env.Set(sym, res), nil
 		case "let*":
-			let_env, e := NewEnv(env, nil, nil)
+			let_env, e := new_env(ec, env)
 			if e != nil {
 				return nil, e
 			}
@@ -197,73 +534,88 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				return nil, e
 			}
 			for i := 0; i < len(arr1); i += 2 {
-				if !Symbol_Q(arr1[i]) {
-					return nil, errors.New("non-symbol bind value")
+				if i+1 >= len(arr1) {
+					return nil, errors.New("odd number of let* bindings")
 				}
-				exp, e := EVAL(arr1[i+1], let_env)
+				exp, e := EVAL(ec, arr1[i+1], let_env)
 				if e != nil {
 					return nil, e
 				}
-				let_env.Set(arr1[i].(Symbol), exp)
+				if e = Bind(ec, let_env, arr1[i], exp, EVAL); e != nil {
+					return nil, e
+				}
 			}
 			ast = a2
 			env = let_env
+		case "binding":
+			arr1, e := GetSlice(a1)
+			if e != nil {
+				return nil, e
+			}
+			frame := map[*Var]MalType{}
+			for i := 0; i < len(arr1); i += 2 {
+				if !Symbol_Q(arr1[i]) || i+1 >= len(arr1) {
+					return nil, errors.New("binding requires pairs of symbols and values")
+				}
+				v, e := GetVar(env, arr1[i].(Symbol))
+				if e != nil {
+					return nil, e
+				}
+				if frame[v], e = EVAL(ec, arr1[i+1], env); e != nil {
+					return nil, e
+				}
+			}
+			return eval_binding(ec, frame, ast.(List).Val[2:], env)
+		case "break*":
+			return nil, debug_repl(ec, ast, env)
 		case "quote":
 			return a1, nil
 		case "quasiquote":
-			ast = quasiquote(a1)
+			ast = quasiquote(a1, map[string]Symbol{})
 		case "defmacro!":
-			fn, e := EVAL(a2, env)
-			fn = fn.(MalFunc).SetMacro()
+			sym, ok := a1.(Symbol)
+			if !ok {
+				return nil, errors.New("defmacro! requires a symbol")
+			}
+			fn, e := EVAL(ec, a2, env)
 			if e != nil {
 				return nil, e
 			}
-			return env.Set(a1.(Symbol), fn), nil
+			mac, ok := fn.(MalFunc)
+			if !ok {
+				return nil, errors.New("defmacro! requires a function")
+			}
+			if mac.Name == "" {
+				mac.Name = sym.Val
+			}
+			clear_macro_cache()
+			return env.Set(sym, mac.SetMacro()), nil
 		case "macroexpand":
-			return macroexpand(a1, env)
+			return macroexpand(ec, a1, env)
+		case "macroexpand-1":
+			return macroexpand_1(ec, a1, env)
+		case "macroexpand-all":
+			return macroexpand_all(ec, a1, env)
 		case "try*":
-			var exc MalType
-			exp, e := EVAL(a1, env)
-			if e == nil {
-				return exp, nil
-			} else {
-				if a2 != nil && List_Q(a2) {
-					a2s, _ := GetSlice(a2)
-					if Symbol_Q(a2s[0]) && (a2s[0].(Symbol).Val == "catch*") {
-						switch e.(type) {
-						case MalError:
-							exc = e.(MalError).Obj
-						default:
-							exc = e.Error()
-						}
-						binds := NewList(a2s[1])
-						new_env, e := NewEnv(env, binds, NewList(exc))
-						if e != nil {
-							return nil, e
-						}
-						exp, e = EVAL(a2s[2], new_env)
-						if e == nil {
-							return exp, nil
-						}
-					}
-				}
-				return nil, e
-			}
+			return eval_try(ec, ast.(List).Val[1:], env)
 		case "do":
 			lst := ast.(List).Val
-			_, e := eval_ast(List{lst[1 : len(lst)-1], nil}, env)
-			if e != nil {
-				return nil, e
-			}
 			if len(lst) == 1 {
 				return nil, nil
 			}
+			_, e := eval_ast(ec, List{lst[1 : len(lst)-1], nil}, env)
+			if e != nil {
+				return nil, e
+			}
 			ast = lst[len(lst)-1]
 		case "if":
-			cond, e := EVAL(a1, env)
+			cond, e := EVAL(ec, a1, env)
 			if e != nil {
 				return nil, e
 			}
+			if cover_counts != nil {
+				cover_mark_if(ast, cond == nil || cond == false)
+			}
 			if cond == nil || cond == false {
 				if len(ast.(List).Val) >= 4 {
 					ast = ast.(List).Val[3]
@@ -274,33 +626,265 @@ func EVAL(ast MalType, env EnvType) (MalType, error) {
 				ast = a2
 			}
 		case "fn*":
-			fn := MalFunc{EVAL, a2, env, a1, false, NewEnv, nil}
+			fn := MalFunc{EVAL, a2, env, a1, false, bind_env, nil, ""}
 			return fn, nil
 		default:
-			el, e := eval_ast(ast, env)
+			el, e := eval_ast(ec, ast, env)
 			if e != nil {
 				return nil, e
 			}
 			f := el.(List).Val[0]
-			if MalFunc_Q(f) {
-				fn := f.(MalFunc)
-				ast = fn.Exp
-				env, e = NewEnv(fn.Env, fn.Params, List{el.(List).Val[1:], nil})
-				if e != nil {
-					return nil, e
-				}
-			} else {
-				fn, ok := f.(Func)
-				if !ok {
-					return nil, errors.New("attempt to call non-function")
+			args := el.(List).Val[1:]
+			name := ""
+			if Symbol_Q(a0) {
+				name = a0.(Symbol).Val
+			}
+			// a core function such as apply may return the call that
+			// gives its result, which is then made here in constant stack
+			for Func_Q(f) {
+				ec.PushFrame(Frame{name, ast})
+				res, e := f.(Func).Fn(ec, args)
+				tc, ok := res.(TailCall)
+				if e != nil || !ok {
+					return res, e
 				}
-				return fn.Fn(el.(List).Val[1:])
+				f, args, name = tc.F, tc.Args, ""
+			}
+			fn, ok := f.(MalFunc)
+			if !ok {
+				return nil, errors.New("attempt to call non-function")
+			}
+			if fn.Name != "" {
+				name = fn.Name
+			}
+			enter_frame(ec, base, name, ast)
+			if len(dbg.breakpoints) > 0 {
+				debug_enter(name)
+			}
+			ast = fn.Exp
+			env, e = fn.GenEnv(ec, fn.Env, fn.Params, List{args, nil})
+			if e != nil {
+				return nil, e
 			}
 		}
 
 	} // TCO loop
 }
 
+// exception_value returns the mal value of an error as bound by catch*.
+// Host errors with a kind become {:type kind :message msg} maps.
+func exception_value(e error) MalType {
+	var me MalError
+	if errors.As(e, &me) {
+		return me.Obj
+	}
+	if kind := ErrorType(e); kind != "" {
+		return HashMap{map[string]MalType{
+			"\u029etype":    "\u029e" + kind,
+			"\u029emessage": e.Error(),
+		}, nil}
+	}
+	return e.Error()
+}
+
+// The limits finally* forms run with after the evaluation they belong
+// to exceeded one of its own. Cleanup such as closing a handle or
+// resetting an atom takes tens of steps, so 10000 leave room for a
+// helper looping over a small collection while a finally* that loops
+// forever still ends within milliseconds. 1000 nested calls are
+// plenty for non-tail recursion over such data and stay far below
+// MaxStackDepth. 16 MiB (a million collection elements) bounds a
+// runaway allocation without failing a cleanup that formats a
+// message.
+const (
+	finally_max_steps = 10000
+	finally_max_depth = 1000
+	finally_max_alloc = 16 << 20
+)
+
+// eval_try evaluates (try* body clause...) where each clause is
+// (catch* e handler), (catch* selector e handler) or
+// (finally* form...). A keyword selector matches exceptions whose :type
+// (or whose ex-data's :type) is that keyword; any other selector is
+// evaluated to a predicate called with the exception. The finally*
+// forms run however the try* exits.
+func eval_try(ec *EvalContext, forms []MalType, env EnvType) (res MalType, err error) {
+	if len(forms) == 0 {
+		return nil, nil
+	}
+	body := forms[0]
+	catches := [][]MalType{}
+	var finally []MalType
+	for _, f := range forms[1:] {
+		clause, ok := f.(List)
+		if !ok || len(clause.Val) == 0 || !Symbol_Q(clause.Val[0]) {
+			return nil, errors.New("try* expects catch* or finally* clauses")
+		}
+		switch clause.Val[0].(Symbol).Val {
+		case "catch*":
+			if len(clause.Val) != 3 && len(clause.Val) != 4 {
+				return nil, errors.New("catch* expects an optional selector, a binding and a handler")
+			}
+			catches = append(catches, clause.Val[1:])
+		case "finally*":
+			finally = clause.Val[1:]
+		default:
+			return nil, errors.New("try* expects catch* or finally* clauses")
+		}
+	}
+	if finally != nil {
+		defer func() {
+			fin := ec
+			if errors.As(err, &LimitError{}) {
+				// the limits are spent: run with a reserve of
+				// their own
+				fin = ec.Reserve(finally_max_steps, finally_max_depth,
+					finally_max_alloc)
+			}
+			if _, e := eval_ast(fin, List{finally, nil}, env); e != nil {
+				res, err = nil, e
+			}
+		}()
+	}
+
+	res, err = EVAL(ec, body, env)
+	if err == nil || errors.As(err, &LimitError{}) || errors.Is(err, debug_aborted) {
+		// exceeding a limit or quitting the debugger cannot be caught
+		return res, err
+	}
+	x := NewException(exception_value(err), ErrorStack(err))
+	exc := x.Value
+	for _, c := range catches {
+		if len(c) == 3 {
+			match, e := catch_matches(ec, c[0], exc, env)
+			if e != nil {
+				return nil, e
+			}
+			if !match {
+				continue
+			}
+			c = c[1:]
+		}
+		new_env, e := new_env(ec, env)
+		if e != nil {
+			return nil, e
+		}
+		if e = Bind(ec, new_env, c[0], exc, EVAL); e != nil {
+			return nil, e
+		}
+		ec.PushException(x)
+		res, err = EVAL(ec, c[1], new_env)
+		ec.PopException()
+		return res, err
+	}
+	return nil, err
+}
+
+func catch_matches(ec *EvalContext, selector MalType, exc MalType, env EnvType) (bool, error) {
+	if Keyword_Q(selector) {
+		hm, ok := exc.(HashMap)
+		if !ok {
+			return false, nil
+		}
+		if Equal_Q(hm.Val["\u029etype"], selector) {
+			return true, nil
+		}
+		data, ok := hm.Val["\u029edata"].(HashMap)
+		return ok && Equal_Q(data.Val["\u029etype"], selector), nil
+	}
+	pred, e := EVAL(ec, selector, env)
+	if e != nil {
+		return false, e
+	}
+	res, e := Apply(ec, pred, []MalType{exc})
+	if e != nil {
+		return false, e
+	}
+	return !(res == nil || res == false), nil
+}
+
+// last_exc is the last exception to reach the REPL (bound to *e), which
+// the evaluations of later lines handle
+var last_exc Exception
+
+// print_error reports an error that reached the top level along with
+// its mal call stack, and makes it available as *e
+func print_error(e error) {
+	last_exc = NewException(exception_value(e), ErrorStack(e))
+	repl_env.Set(Symbol{"*e"}, last_exc.Value)
+	var me MalError
+	if errors.As(e, &me) {
+		fmt.Printf("Error: %s\n", printer.Pr_str(me.Obj, true))
+	} else {
+		fmt.Printf("Error: %v\n", e)
+	}
+	for i, f := range last_exc.Stack {
+		// elide the middle of deep (typically recursive) stacks
+		if n := len(last_exc.Stack); n > 40 && i >= 30 && i < n-10 {
+			if i == 30 {
+				fmt.Printf("  ... %d more\n", n-40)
+			}
+			continue
+		}
+		fmt.Printf("  %s\n", f)
+	}
+}
+
+// eval_with_limits evaluates form in a fresh environment under the
+// limits given by a hash-map with optional :max-steps, :max-depth,
+// :max-alloc (bytes) and :timeout-ms entries
+func eval_with_limits(parent *EvalContext, a []MalType) (MalType, error) {
+	if len(a) != 2 {
+		return nil, NewArityError("wrong number of arguments (%d instead of 2)", len(a))
+	}
+	opts, ok := a[0].(HashMap)
+	if !ok {
+		return nil, NewTypeError("eval-with-limits: expected hash-map of limits")
+	}
+	limit := func(name string) (int, error) {
+		v, ok := opts.Val["\u029e"+name]
+		if !ok || v == nil {
+			return 0, nil
+		}
+		n, ok := v.(int)
+		if !ok || n < 0 {
+			return 0, NewTypeError("eval-with-limits: :%s must be a non-negative number", name)
+		}
+		return n, nil
+	}
+	var n [4]int
+	for i, name := range []string{"max-steps", "max-depth", "max-alloc", "timeout-ms"} {
+		var e error
+		if n[i], e = limit(name); e != nil {
+			return nil, e
+		}
+	}
+
+	ctx := context.Background()
+	if parent != nil && parent.Context != nil {
+		ctx = parent.Context
+	}
+	if n[3] > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(n[3])*time.Millisecond)
+		defer cancel()
+	}
+	ec := NewEvalContext(ctx, n[0], n[1], uint64(n[2]))
+	ec.Nest(parent)
+	defer ec.Done(parent)
+	env, e := new_env(ec, repl_env)
+	if e != nil {
+		return nil, e
+	}
+	res, e := EVAL(ec, a[1], env)
+	var le LimitError
+	if errors.As(e, &le) && ec.Own(le, parent) {
+		// catchable outside the limited evaluation
+		return nil, TypedError{"limit-exceeded", errors.New(e.Error())}
+	}
+	return res, e
+}
+
 // print
 func PRINT(exp MalType) (string, error) {
 	return printer.Pr_str(exp, true), nil
@@ -308,15 +892,38 @@ func PRINT(exp MalType) (string, error) {
 
 var repl_env, _ = NewEnv(nil, nil, nil)
 
+// recover_panic is the last line of defense against interpreter bugs:
+// it turns a Go panic unwinding out of rep into an error carrying the
+// Go stack. The state the unwound calls left behind is that of the
+// evaluation, which ends with them.
+func recover_panic(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*err = TypedError{"internal-error",
+		fmt.Errorf("internal error: %v\n%s", r, debug.Stack())}
+}
+
 // repl
 func rep(str string) (MalType, error) {
+	return rep_context(context.Background(), str)
+}
+
+// rep_context runs rep as an evaluation that ctx interrupts
+func rep_context(ctx context.Context, str string) (_ MalType, err error) {
+	defer recover_panic(&err)
 	var exp MalType
 	var res string
 	var e error
 	if exp, e = READ(str); e != nil {
 		return nil, e
 	}
-	if exp, e = EVAL(exp, repl_env); e != nil {
+	ec := NewEvalContext(ctx, 0, 0, 0)
+	if last_exc.Stack != nil {
+		ec.PushException(last_exc)
+	}
+	if exp, e = EVAL(ec, exp, repl_env); e != nil {
 		return nil, e
 	}
 	if res, e = PRINT(exp); e != nil {
@@ -325,37 +932,110 @@ func rep(str string) (MalType, error) {
 	return res, nil
 }
 
-func main() {
+// rep_interruptible runs rep with SIGINT interrupting the evaluation
+// instead of terminating the process
+func rep_interruptible(str string) (MalType, error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-sigs:
+			cancel(ErrInterrupted)
+		case <-ctx.Done():
+		}
+	}()
+
+	return rep_context(ctx, str)
+}
+
+// setup_env fills repl_env with the core functions and the core.mal
+// definitions
+func setup_env() {
 	// core.go: defined using go
 	for k, v := range core.NS {
-		repl_env.Set(Symbol{k}, Func{v.(func([]MalType) (MalType, error)), nil})
+		repl_env.Set(Symbol{k}, Func{v.(func(*EvalContext, []MalType) (MalType, error)), nil})
 	}
-	repl_env.Set(Symbol{"eval"}, Func{func(a []MalType) (MalType, error) {
-		return EVAL(a[0], repl_env)
+	repl_env.Set(Symbol{"eval"}, Func{func(ec *EvalContext, a []MalType) (MalType, error) {
+		if len(a) != 1 {
+			return nil, NewArityError("wrong number of arguments (%d instead of 1)", len(a))
+		}
+		return EVAL(ec, a[0], repl_env)
 	}, nil})
+	repl_env.Set(Symbol{"eval-with-limits"}, Func{eval_with_limits, nil})
+	repl_env.Set(Symbol{"set-breakpoint!"}, Func{set_breakpoint, nil})
+	repl_env.Set(Symbol{"clear-breakpoint!"}, Func{clear_breakpoint, nil})
+	repl_env.Set(Symbol{"breakpoints"}, Func{breakpoints, nil})
+	repl_env.Set(Symbol{"profile*"}, Func{profile_call, nil})
 	repl_env.Set(Symbol{"*ARGV*"}, List{})
+	repl_env.Set(Symbol{"*command-line-args*"}, List{})
+	repl_env.Set(Symbol{"*e"}, nil)
+	repl_env.Set(core.Out.Sym, core.Out)
 
 	// core.mal: defined using the language itself
 	rep("(def! *host-language* \"go\")")
 	rep("(def! not (fn* (a) (if a false true)))")
-	rep("(def! load-file (fn* (f) (eval (read-string (str \"(do \" (slurp f) \")\")))))")
+	rep("(def! load-file (fn* (f) (eval (cons 'do (read-forms (slurp f) f)))))")
 	rep("(defmacro! cond (fn* (& xs) (if (> (count xs) 0) (list 'if (first xs) (if (> (count xs) 1) (nth xs 1) (throw \"odd number of forms to cond\")) (cons 'cond (rest (rest xs)))))))")
+	rep("(defmacro! with-out-str (fn* (& body) `(binding [*out* (string-writer)] (do ~@body) (str *out*))))")
+	rep("(defmacro! delay (fn* (& body) `(delay* (fn* () (do ~@body)))))")
+	rep("(defmacro! break (fn* () '(break*)))")
+	rep("(defmacro! profile (fn* (& body) `(profile* (fn* () (do ~@body)))))")
+	rep("(defmacro! with-open (fn* (bindings & body) (if (empty? bindings) `(do ~@body) `(let* (~(first bindings) ~(nth bindings 1)) (try* (with-open ~(drop 2 bindings) ~@body) (finally* (close ~(first bindings))))))))")
 	rep("(def! *gensym-counter* (atom 0))")
 	rep("(def! gensym (fn* [] (symbol (str \"G__\" (swap! *gensym-counter* (fn* [x] (+ 1 x)))))))")
-	rep("(defmacro! or (fn* (& xs) (if (empty? xs) nil (if (= 1 (count xs)) (first xs) (let* (condvar (gensym)) `(let* (~condvar ~(first xs)) (if ~condvar ~condvar (or ~@(rest xs)))))))))")
+	rep("(defmacro! or (fn* (& xs) (if (empty? xs) nil (if (= 1 (count xs)) (first xs) `(let* (condvar# ~(first xs)) (if condvar# condvar# (or ~@(rest xs))))))))")
+	TailCalls = true
+}
+
+func main() {
+	setup_env()
+
+	profile_path := flag.String("profile", "", "write a pprof profile of the evaluation to `file`")
+	coverage_path := flag.String("coverage", "", "write lcov line coverage of loaded files to `file`")
+	flag.IntVar(&MaxStackDepth, "max-stack-depth", MaxStackDepth, "raise a stack overflow error beyond `n` nested calls, 0 for no limit")
+	flag.Parse()
+	var p *profile
+	if *profile_path != "" {
+		p = prof_start()
+	}
+	if *coverage_path != "" {
+		cover_start()
+	}
+	exit := func(status int) {
+		if cover_counts != nil {
+			cover_summary(os.Stderr)
+			if e := cover_save(*coverage_path); e != nil {
+				fmt.Printf("Error: %v\n", e)
+				status = 1
+			}
+		}
+		if p != nil {
+			prof_stop(p)
+			if e := prof_save(*profile_path, p); e != nil {
+				fmt.Printf("Error: %v\n", e)
+				status = 1
+			}
+		}
+		os.Exit(status)
+	}
+	core.Exit = exit
 
 	// called with mal script to load and eval
-	if len(os.Args) > 1 {
-		args := make([]MalType, 0, len(os.Args)-2)
-		for _, a := range os.Args[2:] {
+	if flag.NArg() > 0 {
+		args := make([]MalType, 0, flag.NArg()-1)
+		for _, a := range flag.Args()[1:] {
 			args = append(args, a)
 		}
 		repl_env.Set(Symbol{"*ARGV*"}, List{args, nil})
-		if _, e := rep("(load-file \"" + os.Args[1] + "\")"); e != nil {
-			fmt.Printf("Error: %v\n", e)
-			os.Exit(1)
+		repl_env.Set(Symbol{"*command-line-args*"}, List{args, nil})
+		if _, e := rep("(load-file \"" + flag.Arg(0) + "\")"); e != nil {
+			print_error(e)
+			exit(1)
 		}
-		os.Exit(0)
+		exit(0)
 	}
 
 	// repl loop
@@ -364,15 +1044,15 @@ func main() {
 		text, err := readline.Readline("user> ")
 		text = strings.TrimRight(text, "\n")
 		if err != nil {
-			return
+			exit(0)
 		}
 		var out MalType
 		var e error
-		if out, e = rep(text); e != nil {
+		if out, e = rep_interruptible(text); e != nil {
 			if e.Error() == "<empty line>" {
 				continue
 			}
-			fmt.Printf("Error: %v\n", e)
+			print_error(e)
 			continue
 		}
 		fmt.Printf("%v\n", out)
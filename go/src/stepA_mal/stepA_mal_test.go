@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"printer"
+	"reader"
+	. "types"
+)
+
+var setup sync.Once
+
+func test_rep(t *testing.T, str string) string {
+	t.Helper()
+	setup.Do(setup_env)
+	res, e := rep(str)
+	if e != nil {
+		t.Fatalf("%s: %v", str, e)
+	}
+	return res.(string)
+}
+
+func test_rep_error(t *testing.T, str string) error {
+	t.Helper()
+	setup.Do(setup_env)
+	_, e := rep(str)
+	if e == nil {
+		t.Fatalf("%s: expected an error", str)
+	}
+	return e
+}
+
+// capture returns what f writes to stdout
+func capture(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, e := os.Pipe()
+	if e != nil {
+		t.Fatal(e)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	return <-out
+}
+
+// debug_script answers the debugger's prompts with cmds, then with the
+// end of input
+func debug_script(t *testing.T, cmds ...string) {
+	prev := debug_readline
+	debug_readline = func(prompt string) (string, error) {
+		if len(cmds) == 0 {
+			return "", io.EOF
+		}
+		cmd := cmds[0]
+		cmds = cmds[1:]
+		return cmd, nil
+	}
+	t.Cleanup(func() {
+		debug_readline = prev
+		dbg.mode = debug_run
+		for k := range dbg.breakpoints {
+			delete(dbg.breakpoints, k)
+		}
+		CallHook = nil
+	})
+}
+
+// in_order checks that the lines appear in out in order
+func in_order(t *testing.T, out string, lines ...string) {
+	t.Helper()
+	rest := out
+	for _, l := range lines {
+		i := strings.Index(rest, l)
+		if i < 0 {
+			t.Fatalf("expected %q in order in output:\n%s", l, out)
+		}
+		rest = rest[i+len(l):]
+	}
+}
+
+func TestDebuggerBreakpointThroughMap(t *testing.T) {
+	test_rep(t, "(def! dbg-f (fn* (x) (* (+ x 1) 2)))")
+	test_rep(t, "(set-breakpoint! 'dbg-f)")
+	debug_script(t, "x", ":s", ":f", ":c", "x", ":c")
+	var res string
+	out := capture(t, func() { res = test_rep(t, "(map dbg-f [1 2])") })
+	if res != "(4 6)" {
+		t.Errorf("got %s, expected (4 6)", res)
+	}
+	in_order(t, out,
+		"-> (* (+ x 1) 2)", "\n1\n",
+		"-> (+ x 1)", "-> (+ x 1)",
+		"-> (* (+ x 1) 2)", "\n2\n")
+}
+
+func TestDebuggerStepOverAndOut(t *testing.T) {
+	test_rep(t, "(def! dbg-g (fn* (x) (+ (dbg-h x) 1)))")
+	test_rep(t, "(def! dbg-h (fn* (y) (* y 10)))")
+	test_rep(t, "(set-breakpoint! 'dbg-g)")
+	debug_script(t, ":s", ":s", ":o", ":c")
+	var res string
+	out := capture(t, func() { res = test_rep(t, "(dbg-g 2)") })
+	if res != "21" {
+		t.Errorf("got %s, expected 21", res)
+	}
+	in_order(t, out, "-> (+ (dbg-h x) 1)", "-> (dbg-h x)", "-> (* y 10)")
+	if n := strings.Count(out, "->"); n != 3 {
+		t.Errorf("stopped %d times instead of 3:\n%s", n, out)
+	}
+}
+
+func TestDebuggerBreakAndQuit(t *testing.T) {
+	debug_script(t, "(+ 1 2)", ":q")
+	var e error
+	out := capture(t, func() { e = test_rep_error(t, "(let* (a 1) (do (break) (throw \"not reached\")))") })
+	if e.Error() != "debugger: evaluation aborted" {
+		t.Errorf("unexpected error: %v", e)
+	}
+	in_order(t, out, "-> (break*)", "\n3\n")
+}
+
+func TestDebuggerQuitThroughTry(t *testing.T) {
+	test_rep(t, "(def! dbg-after-quit (atom 0))")
+	debug_script(t, ":q")
+	var e error
+	capture(t, func() {
+		e = test_rep_error(t, "(do (try* (break) (catch* e :caught) (finally* (reset! dbg-after-quit 1))) (reset! dbg-after-quit 2))")
+	})
+	if e != debug_aborted {
+		t.Errorf("unexpected error: %v", e)
+	}
+	if res := test_rep(t, "@dbg-after-quit"); res != "1" {
+		t.Errorf("evaluation went on after :q, got %s", res)
+	}
+}
+
+func TestInterruptRunningEvaluation(t *testing.T) {
+	test_rep(t, "(def! int-spin (fn* () (int-spin)))")
+	test_rep(t, "(def! int-kept 1)")
+	// keep an early SIGINT from terminating the test
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	for _, str := range []string{
+		"(do (def! int-during 2) (try* (int-spin) (catch* e :caught)))",
+		// not caught as exceeding the limits of eval-with-limits
+		"(try* (eval-with-limits {:timeout-ms 60000} '(int-spin)) (catch* e :caught))",
+	} {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p, _ := os.FindProcess(os.Getpid())
+			p.Signal(os.Interrupt)
+		}()
+		_, e := rep_interruptible(str)
+		if e == nil || e.Error() != "interrupted" {
+			t.Fatalf("%s: expected interrupted error, got %v", str, e)
+		}
+	}
+	for str, exp := range map[string]string{"int-kept": "1", "int-during": "2", "(+ 1 2)": "3"} {
+		if res := test_rep(t, str); res != exp {
+			t.Errorf("%s: got %s, expected %s", str, res, exp)
+		}
+	}
+}
+
+func TestCoverageOfBranchAtoms(t *testing.T) {
+	setup.Do(setup_env)
+	read_forms, _ := repl_env.Get(Symbol{"read-forms"})
+	defer func() {
+		cover_counts = nil
+		cover_atoms = nil
+		reader.ElementPositions = false
+		repl_env.Set(Symbol{"read-forms"}, read_forms)
+	}()
+	cover_start()
+	file := filepath.Join(t.TempDir(), "classify.mal")
+	src := `; comment on line 1
+(def! classify (fn* (n)
+  (cond
+    (= n 1) :one
+    (= n 2) :two
+    :else :many)))
+(classify 1)
+(classify 5)
+`
+	if e := os.WriteFile(file, []byte(src), 0644); e != nil {
+		t.Fatal(e)
+	}
+	test_rep(t, `(load-file "`+file+`")`)
+	var b strings.Builder
+	if e := cover_write_lcov(&b); e != nil {
+		t.Fatal(e)
+	}
+	want := "TN:\nSF:" + file + "\n" +
+		"DA:2,1\nDA:3,2\nDA:4,1\nDA:5,0\nDA:6,1\nDA:7,1\nDA:8,1\n" +
+		"LF:7\nLH:6\nend_of_record\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+	classify, _ := repl_env.Get(Symbol{"classify"})
+	body := printer.Pr_str(classify.(MalFunc).Exp, true)
+	if want := "(cond (= n 1) :one (= n 2) :two :else :many)"; body != want {
+		t.Errorf("body got %s, expected %s", body, want)
+	}
+}
+
+// BenchmarkMacroExpansion runs the function of tests/perf_macros.mal,
+// whose body uses macros, with and without the macro expansion cache
+func BenchmarkMacroExpansion(b *testing.B) {
+	setup.Do(setup_env)
+	for _, str := range []string{
+		"(defmacro! unless (fn* [c a b] `(if ~c ~b ~a)))",
+		`(def! with-macros
+		  (fn* [n]
+		    (cond
+		      (or (= n 1) (= n 2)) :small
+		      (unless (> n 100) true false) :medium
+		      "else" :large)))`,
+	} {
+		if _, e := rep(str); e != nil {
+			b.Fatal(e)
+		}
+	}
+	call, e := READ("(do (with-macros 1) (with-macros 50) (with-macros 500))")
+	if e != nil {
+		b.Fatal(e)
+	}
+	ec := NewEvalContext(context.Background(), 0, 0, 0)
+	defer func() { macro_caching = true }()
+	for _, caching := range []bool{true, false} {
+		name := map[bool]string{true: "cached", false: "uncached"}[caching]
+		b.Run(name, func(b *testing.B) {
+			macro_caching = caching
+			for i := 0; i < b.N; i += 1 {
+				if _, e := EVAL(ec, call, repl_env); e != nil {
+					b.Fatal(e)
+				}
+			}
+		})
+	}
+}
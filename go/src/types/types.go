@@ -1,10 +1,19 @@
 package types
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // Errors/Exceptions
@@ -16,6 +25,46 @@ func (e MalError) Error() string {
 	return fmt.Sprintf("%#v", e.Obj)
 }
 
+// TypedError is an error raised by the host with a kind of failure,
+// which catch* exposes as the :type of a structured exception map
+type TypedError struct {
+	Type string
+	Err  error
+}
+
+func (e TypedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e TypedError) Unwrap() error {
+	return e.Err
+}
+
+func NewTypeError(format string, a ...interface{}) error {
+	return TypedError{"type-error", fmt.Errorf(format, a...)}
+}
+
+func NewArityError(format string, a ...interface{}) error {
+	return TypedError{"arity-error", fmt.Errorf(format, a...)}
+}
+
+// ErrorType returns the kind of a host error, or "" if it has none
+func ErrorType(e error) string {
+	var te TypedError
+	var pe *os.PathError
+	var le *os.LinkError
+	var se *os.SyscallError
+	switch {
+	case errors.As(e, &te):
+		return te.Type
+	case errors.As(e, &LimitError{}):
+		return "limit-error"
+	case errors.As(e, &pe), errors.As(e, &le), errors.As(e, &se):
+		return "io-error"
+	}
+	return ""
+}
+
 // General types
 type MalType interface {
 }
@@ -41,9 +90,14 @@ func False_Q(obj MalType) bool {
 	return ok && b == false
 }
 
+// Numbers are ints or, as read from literals with a decimal point or
+// an exponent, float64s
 func Number_Q(obj MalType) bool {
-	_, ok := obj.(int)
-	return ok
+	switch obj.(type) {
+	case int, float64:
+		return true
+	}
+	return false
 }
 
 // Symbols
@@ -74,7 +128,7 @@ func String_Q(obj MalType) bool {
 
 // Functions
 type Func struct {
-	Fn   func([]MalType) (MalType, error)
+	Fn   func(*EvalContext, []MalType) (MalType, error)
 	Meta MalType
 }
 
@@ -84,13 +138,14 @@ func Func_Q(obj MalType) bool {
 }
 
 type MalFunc struct {
-	Eval    func(MalType, EnvType) (MalType, error)
+	Eval    func(*EvalContext, MalType, EnvType) (MalType, error)
 	Exp     MalType
 	Env     EnvType
 	Params  MalType
 	IsMacro bool
-	GenEnv  func(EnvType, MalType, MalType) (EnvType, error)
+	GenEnv  func(*EvalContext, EnvType, MalType, MalType) (EnvType, error)
 	Meta    MalType
+	Name    string
 }
 
 func MalFunc_Q(obj MalType) bool {
@@ -107,22 +162,501 @@ func (f MalFunc) GetMacro() bool {
 	return f.IsMacro
 }
 
+// CallHook, if set, is called with the name of each mal function
+// Apply calls, before its body is evaluated
+var CallHook func(name string)
+
 // Take either a MalFunc or regular function and apply it to the
-// arguments
-func Apply(f_mt MalType, a []MalType) (MalType, error) {
+// arguments, as part of the evaluation ec
+func Apply(ec *EvalContext, f_mt MalType, a []MalType) (MalType, error) {
 	switch f := f_mt.(type) {
 	case MalFunc:
-		env, e := f.GenEnv(f.Env, f.Params, List{a, nil})
+		env, e := f.GenEnv(ec, f.Env, f.Params, List{a, nil})
 		if e != nil {
 			return nil, e
 		}
-		return f.Eval(f.Exp, env)
+		if e = ec.EnterStack(); e != nil {
+			return nil, e
+		}
+		base := len(ec.CallStack())
+		ec.PushFrame(Frame{f.Name, nil})
+		if CallHook != nil {
+			CallHook(f.Name)
+		}
+		res, e := f.Eval(ec, f.Exp, env)
+		if e != nil {
+			e = ec.WithStack(e)
+		}
+		ec.PopFrames(base)
+		ec.LeaveStack()
+		return res, e
 	case Func:
-		return f.Fn(a)
+		res, e := f.Fn(ec, a)
+		return apply_tail(ec, res, e)
 	case func([]MalType) (MalType, error):
-		return f(a)
+		res, e := f(a)
+		return apply_tail(ec, res, e)
 	default:
-		return nil, errors.New("Invalid function to Apply")
+		return nil, NewTypeError("Invalid function to Apply")
+	}
+}
+
+// Tail calls
+
+// TailCall is returned by a core function whose result is that of
+// calling F with Args, so that the evaluator can make the call in its
+// TCO loop rather than nesting it on the Go stack
+type TailCall struct {
+	F    MalType
+	Args []MalType
+}
+
+// TailCalls is set by an evaluator that makes the TailCall results of
+// the Funcs it calls itself. Otherwise core functions call directly.
+var TailCalls bool
+
+// TailApply is Apply for a call in tail position of a core function
+func TailApply(ec *EvalContext, f MalType, a []MalType) (MalType, error) {
+	if TailCalls {
+		return TailCall{f, a}, nil
+	}
+	return Apply(ec, f, a)
+}
+
+func apply_tail(ec *EvalContext, res MalType, e error) (MalType, error) {
+	if tc, ok := res.(TailCall); ok && e == nil {
+		return Apply(ec, tc.F, tc.Args)
+	}
+	return res, e
+}
+
+// Stack depth
+
+// MaxStackDepth bounds the nesting of EVAL and Apply invocations so that
+// deep non-tail recursion raises a catchable error before it exhausts
+// the Go stack, which would abort the process. 0 for no limit.
+var MaxStackDepth = 200000
+
+// StackDepth returns the number of EVAL and Apply invocations in
+// progress in the evaluation
+func (c *EvalContext) StackDepth() int {
+	if c == nil {
+		return 0
+	}
+	return c.thread.depth
+}
+
+func (c *EvalContext) EnterStack() error {
+	if c == nil {
+		return nil
+	}
+	if MaxStackDepth > 0 && c.thread.depth >= MaxStackDepth {
+		return TypedError{"stack-overflow", errors.New("stack overflow")}
+	}
+	c.thread.depth += 1
+	return nil
+}
+
+func (c *EvalContext) LeaveStack() {
+	if c != nil {
+		c.thread.depth -= 1
+	}
+}
+
+// Call stack
+type Frame struct {
+	Name string
+	Form MalType
+}
+
+// CallStack returns a Frame for each mal function call in progress in
+// the evaluation, innermost last
+func (c *EvalContext) CallStack() []Frame {
+	if c == nil {
+		return nil
+	}
+	return c.thread.stack
+}
+
+// FrameHook, if set, is called with each frame after it is pushed onto
+// a call stack, and with enter false before it is popped
+var FrameHook func(f Frame, enter bool)
+
+func (c *EvalContext) PushFrame(f Frame) {
+	if c == nil {
+		return
+	}
+	c.thread.stack = append(c.thread.stack, f)
+	if FrameHook != nil {
+		FrameHook(f, true)
+	}
+}
+
+// PopFrames pops frames until n remain
+func (c *EvalContext) PopFrames(n int) {
+	if c == nil {
+		return
+	}
+	t := c.thread
+	for len(t.stack) > n {
+		if FrameHook != nil {
+			FrameHook(t.stack[len(t.stack)-1], false)
+		}
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+}
+
+func (f Frame) String() string {
+	name := f.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if loc := SourceLocation(f.Form); loc != "" {
+		return "at " + name + " (" + loc + ")"
+	}
+	return "at " + name
+}
+
+// SourceLocation returns file:line:column for a list read by the
+// reader, or "" if its position is unknown
+func SourceLocation(form MalType) string {
+	var m map[string]MalType
+	if lst, ok := form.(List); ok {
+		if hm, ok := lst.Meta.(HashMap); ok {
+			m = hm.Val
+		}
+	}
+	line, ok := m["\u029eline"].(int)
+	if !ok {
+		return ""
+	}
+	file, ok := m["\u029efile"].(string)
+	if !ok {
+		file = "<repl>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, line, m["\u029ecolumn"])
+}
+
+// TracedError is an error annotated with the call stack at the point
+// where it was raised
+type TracedError struct {
+	Err   error
+	Stack []Frame
+}
+
+func (e TracedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e TracedError) Unwrap() error {
+	return e.Err
+}
+
+// WithStack annotates e with a copy of the call stack of the evaluation
+// unless it already carries one
+func (c *EvalContext) WithStack(e error) error {
+	var te TracedError
+	if len(c.CallStack()) == 0 || errors.As(e, &te) {
+		return e
+	}
+	stack := make([]Frame, len(c.thread.stack))
+	copy(stack, c.thread.stack)
+	return TracedError{e, stack}
+}
+
+// ErrorStack returns the call stack attached to e, innermost first
+func ErrorStack(e error) []Frame {
+	var te TracedError
+	if !errors.As(e, &te) {
+		return nil
+	}
+	stack := make([]Frame, 0, len(te.Stack))
+	for i := len(te.Stack) - 1; i >= 0; i -= 1 {
+		stack = append(stack, te.Stack[i])
+	}
+	return stack
+}
+
+// Exceptions being handled
+
+// Exception is an exception value together with the call stack it was
+// raised at, innermost first
+type Exception struct {
+	Value MalType
+	Stack []Frame
+}
+
+// NewException returns the exception of value raised with stack. A
+// string or a collection is copied, so that the exception is a value of
+// its own even when the same one is raised again.
+func NewException(value MalType, stack []Frame) Exception {
+	switch v := value.(type) {
+	case string:
+		value = strings.Clone(v)
+	case List:
+		value = List{append([]MalType{}, v.Val...), v.Meta}
+	case Vector:
+		value = Vector{append([]MalType{}, v.Val...), v.Meta}
+	case HashMap:
+		m := make(map[string]MalType, len(v.Val))
+		for k, x := range v.Val {
+			m[k] = x
+		}
+		value = HashMap{m, v.Meta}
+	}
+	return Exception{value, stack}
+}
+
+// PushException and PopException record an exception as handled in the
+// evaluation, as catch* does while its handler runs
+func (c *EvalContext) PushException(x Exception) {
+	c.thread.handled = append(c.thread.handled, x)
+}
+
+func (c *EvalContext) PopException() {
+	c.thread.handled = c.thread.handled[:len(c.thread.handled)-1]
+}
+
+// ExceptionStack returns the call stack of value if it is an exception
+// handled in the evaluation. Exceptions are looked up by identity, so an
+// equal value raised elsewhere is not mistaken for it, and a value
+// without one, such as a number, has no stack.
+func (c *EvalContext) ExceptionStack(value MalType) []Frame {
+	if c == nil {
+		return nil
+	}
+	h := c.thread.handled
+	for i := len(h) - 1; i >= 0; i -= 1 {
+		if identical(h[i].Value, value) {
+			return h[i].Stack
+		}
+	}
+	return nil
+}
+
+// identical reports whether a and b are the same value rather than equal
+// ones: strings and collections sharing their storage, or the same
+// reference. Numbers, booleans, nil and empty strings and collections
+// have no identity.
+func identical(a, b MalType) bool {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return ok && len(x) > 0 && len(x) == len(y) &&
+			unsafe.StringData(x) == unsafe.StringData(y)
+	case List:
+		y, ok := b.(List)
+		return ok && same_slice(x.Val, y.Val)
+	case Vector:
+		y, ok := b.(Vector)
+		return ok && same_slice(x.Val, y.Val)
+	case HashMap:
+		y, ok := b.(HashMap)
+		return ok && len(x.Val) > 0 &&
+			reflect.ValueOf(x.Val).Pointer() == reflect.ValueOf(y.Val).Pointer()
+	case Func:
+		y, ok := b.(Func)
+		return ok && same_fn(x.Fn, y.Fn)
+	case *Atom, *Var, *Handle, *Process, *Delay:
+		return a == b
+	}
+	return false
+}
+
+func same_slice(a, b []MalType) bool {
+	return len(a) > 0 && len(a) == len(b) && &a[0] == &b[0]
+}
+
+// Evaluation contexts
+
+// EvalContext is passed through EVAL, Apply and the core functions
+// for one evaluation. It carries the evaluation's context.Context,
+// the limits it runs under and what it has used of them, and the
+// state of the evaluation: its dynamic bindings and call stack. A nil
+// *EvalContext is an evaluation without limits or such state.
+type EvalContext struct {
+	Context   context.Context
+	MaxSteps  int    // EVAL iterations, 0 for no limit
+	MaxDepth  int    // nested EVAL invocations, 0 for no limit
+	MaxAlloc  uint64 // bytes allocated, 0 for no limit
+	steps     int
+	depth     int
+	alloc     uint64
+	inherited inherited_limits
+	thread    *eval_thread
+}
+
+// eval_thread is the state of an evaluation, which the evaluations
+// nested in it under limits of their own share
+type eval_thread struct {
+	bindings []map[*Var]MalType
+	stack    []Frame
+	depth    int // of the Go stack, in EVAL and Apply invocations
+	handled  []Exception
+}
+
+// inherited_limits records the limits of an EvalContext clamped by Nest
+// to what remained of its parent's
+type inherited_limits struct {
+	steps, depth, alloc bool
+}
+
+// LimitError is raised when an evaluation exceeds a limit of its
+// EvalContext or its context.Context is done
+type LimitError struct {
+	Limit string
+	Err   error
+}
+
+// ErrInterrupted is the cancellation cause of an evaluation interrupted
+// by the user
+var ErrInterrupted = errors.New("interrupted")
+
+func (e LimitError) Error() string {
+	if errors.Is(e.Err, ErrInterrupted) {
+		return ErrInterrupted.Error()
+	}
+	if e.Err != nil {
+		return "evaluation " + e.Limit + ": " + e.Err.Error()
+	}
+	return "evaluation limit exceeded: " + e.Limit
+}
+
+func NewEvalContext(ctx context.Context, max_steps int, max_depth int, max_alloc uint64) *EvalContext {
+	return &EvalContext{ctx, max_steps, max_depth, max_alloc, 0, 0, 0, inherited_limits{}, &eval_thread{}}
+}
+
+// Reserve returns a context with limits of its own for the cleanup of
+// the evaluation c after it exceeded one of its limits
+func (c *EvalContext) Reserve(max_steps int, max_depth int, max_alloc uint64) *EvalContext {
+	r := NewEvalContext(context.Background(), max_steps, max_depth, max_alloc)
+	if c != nil {
+		r.thread = c.thread
+	}
+	return r
+}
+
+// Nest makes c part of the evaluation parent, which it runs inside:
+// c shares parent's bindings and call stack, and its limits are
+// clamped so that it cannot exceed what remains of parent's budgets
+func (c *EvalContext) Nest(parent *EvalContext) {
+	if parent == nil {
+		return
+	}
+	c.thread = parent.thread
+	if parent.MaxSteps > 0 {
+		left := parent.MaxSteps - parent.steps
+		if c.MaxSteps == 0 || c.MaxSteps > left {
+			c.MaxSteps = left
+			c.inherited.steps = true
+		}
+	}
+	if parent.MaxDepth > 0 {
+		left := parent.MaxDepth - parent.depth
+		if c.MaxDepth == 0 || c.MaxDepth > left {
+			c.MaxDepth = left
+			c.inherited.depth = true
+		}
+	}
+	if parent.MaxAlloc > 0 {
+		left := uint64(0)
+		if parent.alloc < parent.MaxAlloc {
+			left = parent.MaxAlloc - parent.alloc
+		}
+		if c.MaxAlloc == 0 || c.MaxAlloc > left {
+			c.MaxAlloc = left
+			c.inherited.alloc = true
+		}
+	}
+}
+
+// Own reports whether e, raised while c was current, exceeded one of
+// c's own limits rather than one inherited from parent, so that it
+// need not stop the evaluation parent is limiting
+func (c *EvalContext) Own(e LimitError, parent *EvalContext) bool {
+	switch e.Limit {
+	case "max-steps":
+		return !c.inherited.steps
+	case "max-depth":
+		return !c.inherited.depth
+	case "max-alloc":
+		return !c.inherited.alloc
+	case "cancelled":
+		// by c's own timeout rather than the parent's context
+		return parent == nil || parent.Context == nil || parent.Context.Err() == nil
+	}
+	return false
+}
+
+// Done charges the steps taken and the bytes allocated by c, which ran
+// inside parent, to parent
+func (c *EvalContext) Done(parent *EvalContext) {
+	if parent != nil {
+		parent.steps += c.steps
+		parent.alloc += c.alloc
+	}
+}
+
+// Step accounts for one EVAL iteration
+func (c *EvalContext) Step() error {
+	if c == nil {
+		return nil
+	}
+	c.steps += 1
+	if c.MaxSteps > 0 && c.steps > c.MaxSteps {
+		return LimitError{"max-steps", nil}
+	}
+	if c.steps%256 == 0 {
+		return c.Check()
+	}
+	return nil
+}
+
+// Check reports whether the context is done
+func (c *EvalContext) Check() error {
+	if c == nil || c.Context == nil {
+		return nil
+	}
+	if c.Context.Err() != nil {
+		return LimitError{"cancelled", context.Cause(c.Context)}
+	}
+	return nil
+}
+
+// Alloc charges n bytes about to be allocated to the allocation budget.
+// What is charged is what the evaluator and the core functions build
+// for the evaluation: collections and environments. The
+// allocations of the Go runtime and of other evaluations are not.
+func (c *EvalContext) Alloc(n uint64) error {
+	if c == nil {
+		return nil
+	}
+	c.alloc += n
+	if c.MaxAlloc > 0 && c.alloc > c.MaxAlloc {
+		return LimitError{"max-alloc", nil}
+	}
+	return nil
+}
+
+// SlotSize is the number of bytes Alloc is charged for each element of
+// a collection
+const SlotSize = uint64(unsafe.Sizeof(MalType(nil)))
+
+// Enter and Leave account for nested EVAL invocations
+func (c *EvalContext) Enter() error {
+	if c == nil {
+		return nil
+	}
+	c.depth += 1
+	if c.MaxDepth > 0 && c.depth > c.MaxDepth {
+		return LimitError{"max-depth", nil}
+	}
+	return nil
+}
+
+func (c *EvalContext) Leave() {
+	if c != nil {
+		c.depth -= 1
 	}
 }
 
@@ -159,7 +693,7 @@ func GetSlice(seq MalType) ([]MalType, error) {
 	case Vector:
 		return obj.Val, nil
 	default:
-		return nil, errors.New("GetSlice called on non-sequence")
+		return nil, NewTypeError("GetSlice called on non-sequence")
 	}
 }
 
@@ -209,6 +743,175 @@ func Atom_Q(obj MalType) bool {
 	return ok
 }
 
+// Dynamic vars
+type Var struct {
+	Sym  Symbol
+	Root MalType
+	Meta MalType
+}
+
+// Deref returns the innermost binding of v in the evaluation, or its
+// root value if v is not rebound there
+func (c *EvalContext) Deref(v *Var) MalType {
+	if c == nil {
+		return v.Root
+	}
+	b := c.thread.bindings
+	for i := len(b) - 1; i >= 0; i -= 1 {
+		if val, ok := b[i][v]; ok {
+			return val
+		}
+	}
+	return v.Root
+}
+
+// PushBindings and PopBindings establish and remove a frame of dynamic
+// bindings, as the binding form does for its body
+func (c *EvalContext) PushBindings(frame map[*Var]MalType) {
+	c.thread.bindings = append(c.thread.bindings, frame)
+}
+
+func (c *EvalContext) PopBindings() {
+	c.thread.bindings = c.thread.bindings[:len(c.thread.bindings)-1]
+}
+
+func Var_Q(obj MalType) bool {
+	_, ok := obj.(*Var)
+	return ok
+}
+
+// I/O handles
+type Handle struct {
+	Name   string
+	Reader *bufio.Reader
+	Writer io.Writer
+	Closer io.Closer
+}
+
+func Handle_Q(obj MalType) bool {
+	_, ok := obj.(*Handle)
+	return ok
+}
+
+// External processes, with handles on their standard streams
+type Process struct {
+	Cmd *exec.Cmd
+	In  *Handle // nil when the input was given up front
+	Out *Handle
+	Err *Handle
+}
+
+func Process_Q(obj MalType) bool {
+	_, ok := obj.(*Process)
+	return ok
+}
+
+// Instants in time
+type Instant struct {
+	T time.Time
+}
+
+func Instant_Q(obj MalType) bool {
+	_, ok := obj.(Instant)
+	return ok
+}
+
+// Regular expressions
+type Regex struct {
+	Re       *regexp.Regexp
+	Anchored *regexp.Regexp // matching the whole input only
+}
+
+func NewRegex(pattern string) (MalType, error) {
+	re, e := regexp.Compile(pattern)
+	if e != nil {
+		return nil, e
+	}
+	return Regex{re, regexp.MustCompile(`^(?:` + pattern + `)$`)}, nil
+}
+
+func Regex_Q(obj MalType) bool {
+	_, ok := obj.(Regex)
+	return ok
+}
+
+// Delays
+type Delay struct {
+	Fn       MalType
+	mu       sync.Mutex    // guards the state of the delay
+	forcer   *eval_thread  // evaluation calling Fn, while it runs
+	running  chan struct{} // closed when Fn returns
+	realized bool
+	val      MalType
+	err      error
+}
+
+func NewDelay(fn MalType) *Delay {
+	return &Delay{Fn: fn}
+}
+
+// Force calls the delay's function the first time it is forced and
+// returns the same value, or error, every time after. Other evaluations
+// forcing the delay while the function runs wait for it to finish; a
+// Force from the evaluation running it comes from the function itself
+// and fails rather than deadlocking.
+func (d *Delay) Force(ec *EvalContext) (MalType, error) {
+	if ec == nil {
+		ec = NewEvalContext(context.Background(), 0, 0, 0)
+	}
+	d.mu.Lock()
+	if d.realized {
+		d.mu.Unlock()
+		return d.val, d.err
+	}
+	if d.forcer == ec.thread {
+		d.mu.Unlock()
+		return nil, errors.New("delay forced while being realized")
+	}
+	if d.forcer != nil {
+		running := d.running
+		d.mu.Unlock()
+		var done <-chan struct{}
+		if ec.Context != nil {
+			done = ec.Context.Done()
+		}
+		select {
+		case <-running:
+			return d.Force(ec)
+		case <-done:
+			return nil, ec.Check()
+		}
+	}
+	d.forcer, d.running = ec.thread, make(chan struct{})
+	fn := d.Fn
+	d.mu.Unlock()
+
+	val, e := Apply(ec, fn, []MalType{})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Fn, d.forcer, d.realized, d.val, d.err = nil, nil, true, val, e
+	close(d.running)
+	return val, e
+}
+
+func (d *Delay) Realized() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.realized
+}
+
+// Value returns the value of a realized delay
+func (d *Delay) Value() MalType {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.val
+}
+
+func Delay_Q(obj MalType) bool {
+	_, ok := obj.(*Delay)
+	return ok
+}
+
 // General functions
 
 func _obj_type(obj MalType) string {
@@ -218,6 +921,55 @@ func _obj_type(obj MalType) string {
 	return reflect.TypeOf(obj).Name()
 }
 
+// TypeName names the type of a mal value for error messages
+func TypeName(obj MalType) string {
+	switch tobj := obj.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int:
+		return "number"
+	case float64:
+		return "float"
+	case string:
+		if Keyword_Q(tobj) {
+			return "keyword"
+		}
+		return "string"
+	case Symbol:
+		return "symbol"
+	case List:
+		return "list"
+	case Vector:
+		return "vector"
+	case HashMap:
+		return "hash-map"
+	case MalFunc:
+		if tobj.GetMacro() {
+			return "macro"
+		}
+		return "function"
+	case Func, func([]MalType) (MalType, error):
+		return "function"
+	case *Atom:
+		return "atom"
+	case *Var:
+		return "var"
+	case *Handle:
+		return "handle"
+	case *Process:
+		return "process"
+	case *Delay:
+		return "delay"
+	case Regex:
+		return "regex"
+	case Instant:
+		return "instant"
+	}
+	return fmt.Sprintf("%T", obj)
+}
+
 func Sequential_Q(seq MalType) bool {
 	if seq == nil {
 		return false
@@ -274,7 +1026,38 @@ func Equal_Q(a MalType, b MalType) bool {
 			}
 		}
 		return true
+	case Instant:
+		return a.(Instant).T.Equal(b.(Instant).T)
+	case Func:
+		return same_fn(a.(Func).Fn, b.(Func).Fn)
+	case MalFunc:
+		// closures of the same fn* form over the same environment
+		af, bf := a.(MalFunc), b.(MalFunc)
+		return same_env(af.Env, bf.Env) && af.IsMacro == bf.IsMacro &&
+			Equal_Q(af.Params, bf.Params) && Equal_Q(af.Exp, bf.Exp)
 	default:
+		if ota != nil && !ota.Comparable() {
+			return false
+		}
 		return a == b
 	}
 }
+
+// same_env reports whether a and b are the same environment, asking
+// environments that are not comparable with ==
+func same_env(a, b EnvType) bool {
+	if s, ok := a.(interface{ Same(EnvType) bool }); ok {
+		return s.Same(b)
+	}
+	return a == b
+}
+
+// same_fn reports whether a and b are the same Go function value. Go
+// funcs are not comparable, but a func value is a pointer to its
+// closure, which identifies it.
+func same_fn(a, b func(*EvalContext, []MalType) (MalType, error)) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(&a)) == *(*unsafe.Pointer)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDelayForcedConcurrently(t *testing.T) {
+	var calls int32
+	d := NewDelay(Func{func(_ *EvalContext, a []MalType) (MalType, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return 42, nil
+	}, nil})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, e := d.Force(nil)
+			if e != nil || res != 42 {
+				t.Errorf("Force() = %v, %v; want 42, nil", res, e)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("delay function called %d times", calls)
+	}
+}
+
+func TestDelayForcedByItself(t *testing.T) {
+	var d *Delay
+	d = NewDelay(Func{func(ec *EvalContext, a []MalType) (MalType, error) {
+		return d.Force(ec)
+	}, nil})
+	if _, e := d.Force(nil); e == nil || e.Error() != "delay forced while being realized" {
+		t.Errorf("Force() error = %v", e)
+	}
+}
+
+func TestBindingsPerEvaluation(t *testing.T) {
+	v := &Var{Symbol{"*x*"}, 1, nil}
+	a := NewEvalContext(context.Background(), 0, 0, 0)
+	b := NewEvalContext(context.Background(), 0, 0, 0)
+	a.PushBindings(map[*Var]MalType{v: 2})
+	nested := NewEvalContext(context.Background(), 100, 0, 0)
+	nested.Nest(a)
+	if a.Deref(v) != 2 || nested.Deref(v) != 2 {
+		t.Errorf("binding not seen by its evaluation")
+	}
+	if b.Deref(v) != 1 {
+		t.Errorf("binding seen by another evaluation")
+	}
+	a.PopBindings()
+	if a.Deref(v) != 1 {
+		t.Errorf("binding not removed")
+	}
+}